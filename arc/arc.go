@@ -0,0 +1,305 @@
+package arc
+
+import (
+	"container/list"
+	"time"
+)
+
+/**
+ARC(Adaptive Replacement Cache) 自适应替换缓存 ---在最近访问(recency)和访问频率(frequency)之间自动调节
+
+结构：
+* T1：只被访问过一次的缓存(最近访问)
+* T2：被访问过至少两次的缓存(访问频率)
+* B1、B2：幽灵链表，只记录最近从 T1、T2 中淘汰的key和大小，不保存值
+* p：T1 的目标容量，T2 的目标容量为 c-p
+
+自适应：
+* 命中 B1 说明 T1 太小，增大 p
+* 命中 B2 说明 T2 太小，减小 p
+淘汰时，T1 超过 p 则淘汰 T1 队尾到 B1，否则淘汰 T2 队尾到 B2。
+
+这里的容量以字节为单位(与 lru.Cache 一致，key 和 Value.Len() 之和)，而不是缓存数目。
+*/
+
+const maxBytes = 1 << 32
+
+// 链表的类型
+const (
+	t1 = iota
+	t2
+	b1
+	b2
+)
+
+type Cache struct {
+	maxBytes int64 // 最大使用内存，即 c
+	p        int64 // T1 的目标容量
+
+	// 默认过期时间，0表示永不过期
+	ttl time.Duration
+
+	lists [4]*list.List // T1, T2, B1, B2
+	bytes [4]int64      // 每个链表中缓存的大小之和
+	cache map[string]*list.Element
+
+	// 是某条记录被移除时的回调函数，可以为 nil
+	onEvicted func(key string, value Value)
+}
+
+// a config for cache
+type CacheConfig struct {
+	MaxBytes int64 // 最大使用内存
+
+	// 默认过期时间，Add 添加的缓存使用该值，0表示永不过期
+	TTL time.Duration
+
+	// 淘汰回调函数
+	OnEvicted func(string, Value)
+}
+
+// Value use Len to count how many bytes it takes
+type Value interface {
+	Len() int
+}
+
+type entry struct {
+	key    string
+	value  Value // 幽灵链表中为 nil
+	size   int64 // key 和 value 的大小
+	expire time.Time
+	list   int // 所在的链表
+}
+
+// expired 判断缓存是否在now时刻已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func New(config *CacheConfig) *Cache {
+	c := &Cache{
+		maxBytes: maxBytes,
+		cache:    make(map[string]*list.Element),
+	}
+	if config != nil {
+		if config.MaxBytes != 0 {
+			c.maxBytes = config.MaxBytes
+		}
+		if config.TTL > 0 {
+			c.ttl = config.TTL
+		}
+		c.onEvicted = config.OnEvicted
+	}
+	for i := range c.lists {
+		c.lists[i] = list.New()
+	}
+	return c
+}
+
+// 按key 添加缓存，使用默认过期时间
+func (c *Cache) Add(key string, val Value) {
+	c.AddWithTTL(key, val, c.ttl)
+}
+
+// AddWithTTL 按key 添加缓存，并指定过期时间，ttl<=0表示永不过期
+func (c *Cache) AddWithTTL(key string, val Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	size := int64(len(key) + val.Len())
+
+	ele, ok := c.cache[key]
+	// 缓存大于 c 时放不下，不淘汰其他缓存，同时删除key原来的值
+	if size > c.maxBytes {
+		if ok {
+			c.Remove(key)
+		}
+		return
+	}
+	if !ok {
+		// 全新的key，进入 T1
+		c.replace(size, false)
+		c.push(&entry{key: key, value: val, size: size, expire: expire}, t1)
+		c.trimGhosts()
+		return
+	}
+
+	e := ele.Value.(*entry)
+	switch e.list {
+	case t1, t2:
+		// 缓存命中，更新缓存内容并移动到 T2
+		c.bytes[e.list] += size - e.size
+		e.value, e.size, e.expire = val, size, expire
+		c.move(ele, t2)
+		c.replace(0, false)
+	case b1:
+		// 命中 B1，T1 太小，增大 p
+		c.p = min64(c.maxBytes, c.p+max64(c.bytes[b2]/max64(c.bytes[b1], 1), 1)*size)
+		c.revive(ele, val, size, expire, false)
+	case b2:
+		// 命中 B2，T2 太小，减小 p
+		c.p = max64(0, c.p-max64(c.bytes[b1]/max64(c.bytes[b2], 1), 1)*size)
+		c.revive(ele, val, size, expire, true)
+	}
+	c.trimGhosts()
+}
+
+// revive 幽灵链表中的key重新被添加，放入 T2
+func (c *Cache) revive(ele *list.Element, val Value, size int64, expire time.Time, inB2 bool) {
+	e := c.unlink(ele)
+	c.replace(size, inB2)
+	e.value, e.size, e.expire = val, size, expire
+	c.push(e, t2)
+}
+
+// replace 淘汰 T1 或 T2 的队尾到对应的幽灵链表，直到能放下 size 大小的缓存
+func (c *Cache) replace(size int64, inB2 bool) {
+	for c.bytes[t1]+c.bytes[t2]+size > c.maxBytes && c.lists[t1].Len()+c.lists[t2].Len() > 0 {
+		if c.lists[t1].Len() > 0 &&
+			(c.bytes[t1] > c.p || (inB2 && c.bytes[t1] == c.p) || c.lists[t2].Len() == 0) {
+			c.evict(c.lists[t1].Back(), b1)
+		} else {
+			c.evict(c.lists[t2].Back(), b2)
+		}
+	}
+}
+
+// trimGhosts 限制幽灵链表的大小：T1+B1 不超过 c，四个链表之和不超过 2c
+func (c *Cache) trimGhosts() {
+	for c.bytes[t1]+c.bytes[b1] > c.maxBytes && c.lists[b1].Len() > 0 {
+		c.unlink(c.lists[b1].Back())
+	}
+	for c.bytes[t1]+c.bytes[t2]+c.bytes[b1]+c.bytes[b2] > 2*c.maxBytes && c.lists[b2].Len() > 0 {
+		c.unlink(c.lists[b2].Back())
+	}
+}
+
+// evict 淘汰缓存，记录到幽灵链表 ghost
+func (c *Cache) evict(ele *list.Element, ghost int) {
+	e := c.unlink(ele)
+	value := e.value
+	e.value = nil
+	c.push(e, ghost)
+
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, value)
+	}
+}
+
+// push 将缓存放入链表 l 的队首
+func (c *Cache) push(e *entry, l int) {
+	e.list = l
+	c.cache[e.key] = c.lists[l].PushFront(e)
+	c.bytes[l] += e.size
+}
+
+// unlink 将缓存从所在的链表中删除
+func (c *Cache) unlink(ele *list.Element) *entry {
+	e := ele.Value.(*entry)
+	c.lists[e.list].Remove(ele)
+	c.bytes[e.list] -= e.size
+	delete(c.cache, e.key)
+	return e
+}
+
+// move 将缓存移动到链表 l 的队首
+func (c *Cache) move(ele *list.Element, l int) {
+	c.push(c.unlink(ele), l)
+}
+
+// 获取缓存，命中时移动到 T2
+func (c *Cache) Get(key string) (val Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.list != t1 && e.list != t2 {
+		return nil, false
+	}
+	// 惰性删除：访问时发现已过期则直接淘汰
+	if e.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.move(ele, t2)
+	return e.value, true
+}
+
+// Peek 获取缓存但不移动到 T2，也不删除过期缓存。
+// 不修改任何内部状态，多个 Peek 可以在读锁下并发调用
+func (c *Cache) Peek(key string) (val Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if (e.list != t1 && e.list != t2) || e.expired(time.Now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// 按key 删除缓存
+func (c *Cache) Remove(key string) bool {
+	ele, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	if e := ele.Value.(*entry); e.list == b1 || e.list == b2 {
+		c.unlink(ele)
+		return false
+	}
+	c.removeElement(ele)
+	return true
+}
+
+// removeElement 删除 T1 或 T2 中的缓存，不记录到幽灵链表
+func (c *Cache) removeElement(ele *list.Element) {
+	e := c.unlink(ele)
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+// RemoveExpired 删除所有已过期的缓存，返回删除的数目
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, l := range []int{t1, t2} {
+		for ele := c.lists[l].Back(); ele != nil; {
+			prev := ele.Prev()
+			if ele.Value.(*entry).expired(now) {
+				c.removeElement(ele)
+				n++
+			}
+			ele = prev
+		}
+	}
+	return n
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.bytes[t1] + c.bytes[t2]
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return c.lists[t1].Len() + c.lists[t2].Len()
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,144 @@
+package arc
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 1 << 10})
+	c.Add("key", String("value"))
+	c.Add("key2", String("value2"))
+
+	if val, ok := c.Get("key"); !ok || string(val.(String)) != "value" {
+		t.Fatal("get value error")
+	}
+	c.Add("key", String("v"))
+	if val, _ := c.Get("key"); string(val.(String)) != "v" {
+		t.Fatal("value should be updated")
+	}
+	if c.Bytes() != int64(len("key"+"v"+"key2"+"value2")) || c.Len() != 2 {
+		t.Fatalf("unexpected bytes %d len %d", c.Bytes(), c.Len())
+	}
+	if !c.Remove("key") || c.Len() != 1 {
+		t.Fatal("remove key failed")
+	}
+}
+
+// 每个缓存占用 4 字节
+func key(i int) string {
+	return "k" + strconv.Itoa(100+i)
+}
+
+func TestScanResistance(t *testing.T) {
+	evicted := 0
+	c := New(&CacheConfig{
+		MaxBytes: 10 * 5,
+		OnEvicted: func(string, Value) {
+			evicted++
+		},
+	})
+
+	// 热点数据访问两次进入 T2
+	for i := 0; i < 5; i++ {
+		c.Add(key(i), String("v"))
+		c.Get(key(i))
+	}
+	// 扫描只访问一次的数据，只会淘汰 T1
+	for i := 100; i < 200; i++ {
+		c.Add(key(i), String("v"))
+	}
+	for i := 0; i < 5; i++ {
+		if _, ok := c.Get(key(i)); !ok {
+			t.Fatalf("hot key %s should survive the scan", key(i))
+		}
+	}
+	if c.Bytes() > 10*5 || evicted != 95 {
+		t.Fatalf("unexpected bytes %d, evicted %d", c.Bytes(), evicted)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 4 * 5})
+	listOf := func(i int) int {
+		return c.cache[key(i)].Value.(*entry).list
+	}
+
+	// k0, k1 进入 T2，k2~k7 中最早的被淘汰到 B1
+	for i := 0; i < 2; i++ {
+		c.Add(key(i), String("v"))
+		c.Get(key(i))
+	}
+	for i := 2; i < 8; i++ {
+		c.Add(key(i), String("v"))
+	}
+	if c.p != 0 || listOf(4) != b1 {
+		t.Fatalf("evicted keys should be in B1, p %d", c.p)
+	}
+
+	// 命中 B1，增大 T1 的目标容量
+	c.Add(key(4), String("v"))
+	if c.p == 0 || listOf(4) != t2 {
+		t.Fatalf("B1 hit should grow p and move key to T2, p %d", c.p)
+	}
+
+	// T1 为空时淘汰 T2，命中 B2 减小 T1 的目标容量
+	for i := 5; i < 8; i++ {
+		c.Get(key(i))
+	}
+	c.Add(key(8), String("v"))
+	if listOf(0) != b2 {
+		t.Fatal("key should be evicted from T2 to B2")
+	}
+	p := c.p
+	c.Add(key(0), String("v"))
+	if c.p >= p || listOf(0) != t2 {
+		t.Fatalf("B2 hit should shrink p and move key to T2, %d >= %d", c.p, p)
+	}
+	if c.Bytes() > 4*5 {
+		t.Fatalf("unexpected bytes %d", c.Bytes())
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	c := New(&CacheConfig{TTL: 10 * time.Millisecond})
+	c.Add("k1", String("v1"))
+	c.AddWithTTL("k2", String("v2"), time.Hour)
+	c.AddWithTTL("k3", String("v3"), time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("k1"); ok {
+		t.Fatal("k1 should be expired")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.Len() != 1 {
+		t.Fatalf("expected 1 expired entry removed, got %d, len %d", n, c.Len())
+	}
+}
+
+func TestAddTooLarge(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 12})
+	c.Add("k1", String("v1"))
+	c.Add("k2", String("v2"))
+
+	// 大于 c 的缓存不会被添加，也不会淘汰其他缓存
+	c.Add("big", String("0123456789"))
+	if _, ok := c.Get("big"); ok {
+		t.Fatal("entry larger than maxBytes should be rejected")
+	}
+	if c.Bytes() != 8 || c.Len() != 2 {
+		t.Fatalf("unexpected bytes %d len %d", c.Bytes(), c.Len())
+	}
+
+	// 更新为过大的值时删除原来的值
+	c.Add("k1", String("0123456789a"))
+	if _, ok := c.Get("k1"); ok || c.Bytes() > 12 {
+		t.Fatalf("oversized update should drop k1, bytes %d", c.Bytes())
+	}
+}
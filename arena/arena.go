@@ -0,0 +1,262 @@
+package arena
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"time"
+)
+
+/**
+Arena 对GC友好的缓存 ---参考 bigcache/freecache，key 和 value 保存在一块预先分配的大字节数组中
+
+结构：
+* buf：环形缓冲区，缓存按写入顺序依次追加，每条缓存为 header + key + value，不会跨越缓冲区末尾
+* index：key 的哈希值 -> 缓存在 buf 中的偏移量，map 中没有指针，GC 不需要扫描
+
+淘汰：
+写到缓冲区末尾放不下时回到开头，覆盖最早写入的缓存(FIFO)。
+删除和更新缓存只修改 index，旧数据留在 buf 中，直到被覆盖。
+哈希冲突时，后写入的缓存覆盖先写入的缓存；读取时会比较 key，不会返回错误的值。
+*/
+
+const (
+	defaultMaxBytes = 64 << 20
+	maxBufferBytes  = 1<<32 - 1 // 偏移量为 uint32
+	maxKeyLen       = 1<<16 - 1
+
+	// header: hash(8) + expire(8) + keyLen(2) + valueLen(4)
+	headerSize = 22
+)
+
+type Cache struct {
+	buf   []byte
+	index map[uint64]uint32
+
+	head    uint32 // 最早写入的缓存的偏移量
+	tail    uint32 // 下一条缓存写入的位置
+	dataEnd uint32 // 回到开头之前最后写入的位置，未回到开头时为 len(buf)
+	entries int    // buf 中的缓存数目，包括已经删除的
+
+	nowBytes int64 // 有效缓存的 key 和 value 大小之和
+
+	// 默认过期时间，0表示永不过期
+	ttl time.Duration
+
+	// 是某条记录被移除时的回调函数，可以为 nil。
+	// value 指向 buf 内部，只在回调期间有效
+	onEvicted func(key string, value []byte)
+}
+
+// a config for cache
+type CacheConfig struct {
+	// 缓冲区大小，创建时一次性分配，默认 64MB。
+	// 每条缓存额外占用 22 字节的 header，删除的缓存被覆盖前也会占用空间
+	MaxBytes int64
+
+	// 默认过期时间，Add 添加的缓存使用该值，0表示永不过期
+	TTL time.Duration
+
+	// 淘汰回调函数
+	OnEvicted func(key string, value []byte)
+}
+
+func New(config *CacheConfig) *Cache {
+	size := int64(defaultMaxBytes)
+	c := &Cache{index: make(map[uint64]uint32)}
+	if config != nil {
+		if config.MaxBytes != 0 {
+			size = config.MaxBytes
+		}
+		if config.TTL > 0 {
+			c.ttl = config.TTL
+		}
+		c.onEvicted = config.OnEvicted
+	}
+	if size > maxBufferBytes {
+		size = maxBufferBytes
+	}
+	c.buf = make([]byte, size)
+	c.dataEnd = uint32(size)
+	return c
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// 按key 添加缓存，使用默认过期时间
+func (c *Cache) Add(key string, value []byte) bool {
+	return c.AddWithTTL(key, value, c.ttl)
+}
+
+// AddWithTTL 按key 添加缓存，并指定过期时间，ttl<=0表示永不过期。
+// value 会被拷贝到缓冲区中，缓存大于缓冲区时返回false
+func (c *Cache) AddWithTTL(key string, value []byte, ttl time.Duration) bool {
+	size := int64(headerSize + len(key) + len(value))
+	if len(key) > maxKeyLen || size > int64(len(c.buf)) {
+		return false
+	}
+
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	h := hash(key)
+
+	// 更新缓存时先删除旧缓存；哈希冲突时旧缓存被淘汰
+	if off, ok := c.index[h]; ok {
+		k, _, _ := c.entry(off)
+		c.removeAt(h, off, string(k) != key)
+	}
+
+	off := c.reserve(uint32(size))
+	hdr := c.buf[off : off+headerSize]
+	binary.LittleEndian.PutUint64(hdr[0:], h)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(expire))
+	binary.LittleEndian.PutUint16(hdr[16:], uint16(len(key)))
+	binary.LittleEndian.PutUint32(hdr[18:], uint32(len(value)))
+	copy(c.buf[off+headerSize:], key)
+	copy(c.buf[off+headerSize+uint32(len(key)):], value)
+
+	c.index[h] = off
+	c.nowBytes += int64(len(key) + len(value))
+	return true
+}
+
+// reserve 在 tail 处腾出 size 大小的空间，覆盖最早写入的缓存，返回写入位置
+func (c *Cache) reserve(size uint32) uint32 {
+	if uint64(c.tail)+uint64(size) > uint64(len(c.buf)) {
+		// 放不下，回到开头。已经回到过开头时，先淘汰末尾剩余的缓存
+		for c.entries > 0 && c.head >= c.tail {
+			c.evictHead()
+		}
+		c.dataEnd = c.tail
+		c.tail = 0
+	}
+	// 淘汰与 [tail, tail+size) 重叠的缓存，它们是最早写入的
+	for c.entries > 0 && c.head >= c.tail && c.head < c.tail+size {
+		c.evictHead()
+	}
+	if c.entries == 0 {
+		c.head, c.dataEnd = c.tail, uint32(len(c.buf))
+	}
+
+	off := c.tail
+	c.tail += size
+	c.entries++
+	return off
+}
+
+// evictHead 淘汰最早写入的缓存
+func (c *Cache) evictHead() {
+	h, _, klen, vlen := c.header(c.head)
+	if off, ok := c.index[h]; ok && off == c.head {
+		c.removeAt(h, c.head, true)
+	}
+	c.head += headerSize + uint32(klen) + vlen
+	c.entries--
+	if c.head >= c.dataEnd {
+		c.head, c.dataEnd = 0, uint32(len(c.buf))
+	}
+}
+
+func (c *Cache) header(off uint32) (h uint64, expire int64, klen uint16, vlen uint32) {
+	hdr := c.buf[off : off+headerSize]
+	return binary.LittleEndian.Uint64(hdr[0:]),
+		int64(binary.LittleEndian.Uint64(hdr[8:])),
+		binary.LittleEndian.Uint16(hdr[16:]),
+		binary.LittleEndian.Uint32(hdr[18:])
+}
+
+// entry 返回 off 处缓存的 key 和 value，指向 buf 内部
+func (c *Cache) entry(off uint32) (key, value []byte, expire int64) {
+	_, expire, klen, vlen := c.header(off)
+	start := off + headerSize
+	key = c.buf[start : start+uint32(klen)]
+	value = c.buf[start+uint32(klen) : start+uint32(klen)+vlen]
+	return key, value, expire
+}
+
+// removeAt 删除 index 中的缓存，数据留在 buf 中等待覆盖。evicted 为true时调用淘汰回调函数
+func (c *Cache) removeAt(h uint64, off uint32, evicted bool) {
+	key, value, _ := c.entry(off)
+	delete(c.index, h)
+	c.nowBytes -= int64(len(key) + len(value))
+	if evicted && c.onEvicted != nil {
+		c.onEvicted(string(key), value)
+	}
+}
+
+// lookup 查找key，返回偏移量和是否已过期
+func (c *Cache) lookup(key string, now time.Time) (h uint64, off uint32, expired bool, ok bool) {
+	h = hash(key)
+	off, ok = c.index[h]
+	if !ok {
+		return
+	}
+	k, _, expire := c.entry(off)
+	if string(k) != key {
+		return h, off, false, false
+	}
+	return h, off, expire != 0 && now.UnixNano() > expire, true
+}
+
+// 获取缓存，返回 value 的拷贝
+func (c *Cache) Get(key string) (value []byte, ok bool) {
+	h, off, expired, ok := c.lookup(key, time.Now())
+	if !ok {
+		return nil, false
+	}
+	// 惰性删除：访问时发现已过期则直接淘汰
+	if expired {
+		c.removeAt(h, off, true)
+		return nil, false
+	}
+	_, v, _ := c.entry(off)
+	return append([]byte(nil), v...), true
+}
+
+// Peek 获取缓存，不删除过期缓存。
+// 不修改任何内部状态，多个 Peek 可以在读锁下并发调用
+func (c *Cache) Peek(key string) (value []byte, ok bool) {
+	_, off, expired, ok := c.lookup(key, time.Now())
+	if !ok || expired {
+		return nil, false
+	}
+	_, v, _ := c.entry(off)
+	return append([]byte(nil), v...), true
+}
+
+// 按key 删除缓存
+func (c *Cache) Remove(key string) bool {
+	h, off, _, ok := c.lookup(key, time.Now())
+	if ok {
+		c.removeAt(h, off, true)
+	}
+	return ok
+}
+
+// RemoveExpired 删除所有已过期的缓存，返回删除的数目
+func (c *Cache) RemoveExpired() int {
+	now := time.Now().UnixNano()
+	n := 0
+	for h, off := range c.index {
+		if _, _, expire := c.entry(off); expire != 0 && now > expire {
+			c.removeAt(h, off, true)
+			n++
+		}
+	}
+	return n
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.index)
+}
@@ -0,0 +1,85 @@
+package arena
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 1 << 10})
+	c.Add("key1", []byte("1234"))
+	if v, ok := c.Get("key1"); !ok || string(v) != "1234" {
+		t.Fatal("cache hit key1=1234 failed")
+	}
+	if _, ok := c.Get("key2"); ok {
+		t.Fatal("cache miss key2 failed")
+	}
+
+	c.Add("key1", []byte("5678"))
+	if v, ok := c.Peek("key1"); !ok || string(v) != "5678" || c.Len() != 1 {
+		t.Fatal("update key1 failed")
+	}
+	if c.Bytes() != int64(len("key1")+len("5678")) {
+		t.Fatalf("unexpected bytes %d", c.Bytes())
+	}
+}
+
+func TestRingEviction(t *testing.T) {
+	evicted := make([]string, 0)
+	// 每条缓存 headerSize + 2 + 2 字节，最多放下 4 条
+	c := New(&CacheConfig{
+		MaxBytes: 4*(headerSize+4) + 10,
+		OnEvicted: func(key string, value []byte) {
+			evicted = append(evicted, key)
+		},
+	})
+
+	for i := 0; i < 10; i++ {
+		c.Add(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("v%d", i)))
+		if c.Len() > 4 {
+			t.Fatalf("len %d exceeds capacity", c.Len())
+		}
+	}
+	// FIFO：最早写入的缓存被覆盖
+	for i := 0; i < 10; i++ {
+		v, ok := c.Get(fmt.Sprintf("k%d", i))
+		if kept := i >= 6; ok != kept || (ok && string(v) != fmt.Sprintf("v%d", i)) {
+			t.Fatalf("k%d: got %q %v", i, v, ok)
+		}
+	}
+	if len(evicted) != 6 || evicted[0] != "k0" {
+		t.Fatalf("unexpected evicted keys %v", evicted)
+	}
+
+	// 删除的缓存不再被淘汰
+	c.Remove("k7")
+	c.Add("k10", []byte("v10"))
+	if c.Len() != 3 || len(evicted) != 8 {
+		t.Fatalf("unexpected len %d, evicted %v", c.Len(), evicted)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 32})
+	if c.Add("key", make([]byte, 32)) || c.Len() != 0 {
+		t.Fatal("entry larger than buffer should be rejected")
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	c := New(&CacheConfig{TTL: 20 * time.Millisecond, MaxBytes: 1 << 10})
+	c.Add("k1", []byte("v1"))
+	c.AddWithTTL("k2", []byte("v2"), time.Hour)
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Peek("k1"); ok {
+		t.Fatal("k1 should be expired")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.Len() != 1 {
+		t.Fatalf("expected 1 expired entry removed, got %d, len %d", n, c.Len())
+	}
+	if _, ok := c.Get("k2"); !ok {
+		t.Fatal("k2 should not expire")
+	}
+}
@@ -0,0 +1,94 @@
+package gocache
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/devhg/gocache/singlereq"
+)
+
+// 批量加载窗口内最多合并的key数目
+const defaultMaxBatch = 100
+
+// batchLoader 将一段时间内并发的单个key加载合并为一次 BatchDataGetter.GetMany 调用。
+// 调用方已经经过 singlereq 去重，同一个key不会同时出现在两个批次中
+type batchLoader struct {
+	getter   BatchDataGetter
+	window   time.Duration // 第一个key到达后等待的时间
+	maxBatch int           // 达到该数目时立即加载，不再等待
+
+	mu      sync.Mutex
+	pending *batch // 正在收集key的批次
+}
+
+// batch 一次 GetMany 调用，done 关闭后 values 和 err 可读
+type batch struct {
+	keys   []string
+	done   chan struct{}
+	values map[string][]byte
+	err    error
+}
+
+// load 将key加入当前批次，等待批次加载完成
+func (l *batchLoader) load(ctx context.Context, key string) ([]byte, error) {
+	l.mu.Lock()
+	b := l.pending
+	if b == nil {
+		b = &batch{done: make(chan struct{})}
+		l.pending = b
+		time.AfterFunc(l.window, func() {
+			l.flush(b)
+		})
+	}
+	b.keys = append(b.keys, key)
+	full := len(b.keys) >= l.maxBatch
+	if full {
+		l.pending = nil
+	}
+	l.mu.Unlock()
+
+	if full {
+		l.run(b)
+	}
+
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		// 批次中还有其他key，不取消加载
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	value, ok := b.values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+// flush 等待时间到，加载批次。批次已经因为达到 maxBatch 被加载时直接返回
+func (l *batchLoader) flush(b *batch) {
+	l.mu.Lock()
+	if l.pending != b {
+		l.mu.Unlock()
+		return
+	}
+	l.pending = nil
+	l.mu.Unlock()
+
+	l.run(b)
+}
+
+// run 调用 GetMany，GetMany panic 时批次中的所有key都得到 *singlereq.PanicError，不会永远等待
+func (l *batchLoader) run(b *batch) {
+	defer close(b.done)
+	defer func() {
+		if r := recover(); r != nil {
+			b.values, b.err = nil, &singlereq.PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	b.values, b.err = l.getter.GetMany(b.keys)
+}
@@ -0,0 +1,141 @@
+package bloom
+
+import (
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+/**
+布隆过滤器 ---用很小的空间判断一个元素是否 "一定不存在" 或者 "可能存在"
+
+算法原理：
+使用一个 m 位的位数组和 k 个哈希函数，添加元素时将 k 个哈希值对应的位置为1，
+查询时只要有一位为0，则元素一定不存在；全部为1则元素可能存在(存在误判)。
+
+给定预计元素数目 n 和期望误判率 p：
+	m = -n*ln(p) / (ln2)^2
+	k = m/n * ln2
+
+k 个哈希函数使用双重哈希(Kirsch-Mitzenmacher)模拟: g_i(x) = h1(x) + i*h2(x)
+*/
+
+// Filter 并发安全的布隆过滤器
+type Filter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64 // 位数组长度
+	k    uint64 // 哈希函数个数
+	n    uint64 // 已添加的元素数目
+}
+
+// New 根据预计元素数目 n 和期望误判率 p 创建布隆过滤器
+func New(n uint, p float64) *Filter {
+	if n == 0 {
+		n = 1
+	}
+	if p <= 0 || p >= 1 {
+		p = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+	return NewWithSize(m, k)
+}
+
+// NewWithSize 使用 m 位的位数组和 k 个哈希函数创建布隆过滤器
+func NewWithSize(m, k uint64) *Filter {
+	if m == 0 {
+		m = 64
+	}
+	if k == 0 {
+		k = 1
+	}
+	return &Filter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// hash 计算双重哈希所需的两个哈希值
+func hash(data []byte) (h1, h2 uint64) {
+	h := fnv.New64a()
+	_, _ = h.Write(data)
+	h1 = h.Sum64()
+
+	// splitmix64 由 h1 派生出 h2，保证 h2 为奇数
+	h2 = h1 + 0x9e3779b97f4a7c15
+	h2 = (h2 ^ (h2 >> 30)) * 0xbf58476d1ce4e5b9
+	h2 = (h2 ^ (h2 >> 27)) * 0x94d049bb133111eb
+	h2 ^= h2 >> 31
+	return h1, h2 | 1
+}
+
+// Add 添加元素
+func (f *Filter) Add(data []byte) {
+	h1, h2 := hash(data)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := uint64(0); i < f.k; i++ {
+		idx := (h1 + i*h2) % f.m
+		f.bits[idx>>6] |= 1 << (idx & 63)
+	}
+	f.n++
+}
+
+// AddString 添加字符串元素
+func (f *Filter) AddString(s string) {
+	f.Add([]byte(s))
+}
+
+// Test 判断元素是否可能存在，返回false表示一定不存在
+func (f *Filter) Test(data []byte) bool {
+	h1, h2 := hash(data)
+
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for i := uint64(0); i < f.k; i++ {
+		idx := (h1 + i*h2) % f.m
+		if f.bits[idx>>6]&(1<<(idx&63)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestString 判断字符串元素是否可能存在
+func (f *Filter) TestString(s string) bool {
+	return f.Test([]byte(s))
+}
+
+// Count 已添加的元素数目
+func (f *Filter) Count() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.n
+}
+
+// Cap 返回位数组长度和哈希函数个数
+func (f *Filter) Cap() (m, k uint64) {
+	return f.m, f.k
+}
+
+// EstimatedFPRate 根据已添加的元素数目估算当前误判率 (1 - e^(-kn/m))^k
+func (f *Filter) EstimatedFPRate() float64 {
+	n := f.Count()
+	return math.Pow(1-math.Exp(-float64(f.k)*float64(n)/float64(f.m)), float64(f.k))
+}
+
+// Reset 清空过滤器
+func (f *Filter) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := range f.bits {
+		f.bits[i] = 0
+	}
+	f.n = 0
+}
@@ -0,0 +1,39 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	n, p := 10000, 0.01
+	f := New(uint(n), p)
+	for i := 0; i < n; i++ {
+		f.AddString(strconv.Itoa(i))
+	}
+
+	// 布隆过滤器不存在漏判
+	for i := 0; i < n; i++ {
+		if !f.TestString(strconv.Itoa(i)) {
+			t.Fatalf("key %d should be contained", i)
+		}
+	}
+
+	fp := 0
+	for i := n; i < 2*n; i++ {
+		if f.TestString(strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if rate := float64(fp) / float64(n); rate > 2*p {
+		t.Fatalf("false positive rate %.4f too high, expected about %.4f", rate, p)
+	}
+	if rate := f.EstimatedFPRate(); rate > 2*p {
+		t.Fatalf("estimated false positive rate %.4f too high", rate)
+	}
+
+	f.Reset()
+	if f.Count() != 0 || f.TestString("1") {
+		t.Fatal("filter should be empty after reset")
+	}
+}
@@ -1,10 +1,19 @@
 package gocache
 
+import "time"
+
 // A ByteView holds an immutable view of bytes.
 type ByteView struct {
 	// 储存真正的缓存值，选择byte类型是为了支持所有的数据类型
 	// b 是只读的，使用 ByteSlice() 方法返回一个拷贝，防止缓存值被外部程序修改
 	b []byte
+	e time.Time // 过期时间，零值表示永不过期
+
+	// ttl 加载时的存活时间，用于提前刷新。0表示永不过期，或者来自远程节点、不需要刷新
+	ttl time.Duration
+
+	// notFound 表示这是一个空值缓存，key 在数据源中不存在
+	notFound bool
 }
 
 // Len returns the view's length
@@ -12,6 +21,21 @@ func (b ByteView) Len() int {
 	return len(b.b)
 }
 
+// Expire returns the expiry time, zero means it never expires.
+func (b ByteView) Expire() time.Time {
+	return b.e
+}
+
+// expired 判断是否在now时刻已经过期
+func (b ByteView) expired(now time.Time) bool {
+	return !b.e.IsZero() && now.After(b.e)
+}
+
+// staleWithin 判断在now时刻过期的时间是否没有超过window，window<=0时返回false
+func (b ByteView) staleWithin(window time.Duration, now time.Time) bool {
+	return window > 0 && !b.expired(now.Add(-window))
+}
+
 // ByteSlice returns a copy of the data as a byte slice.
 func (b ByteView) ByteSlice() []byte {
 	return cloneBytes(b.b)
@@ -27,3 +51,19 @@ func cloneBytes(b []byte) []byte {
 	copy(bytes, b)
 	return bytes
 }
+
+// expireToUnixNano 将过期时间转换为 unix nano，用于节点间传输，0表示永不过期
+func expireToUnixNano(e time.Time) int64 {
+	if e.IsZero() {
+		return 0
+	}
+	return e.UnixNano()
+}
+
+// expireFromUnixNano 将节点间传输的 unix nano 转换为过期时间
+func expireFromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
@@ -1,52 +1,340 @@
 package gocache
 
 import (
+	"hash/fnv"
 	"sync"
-
-	"github.com/devhg/gocache/lru"
+	"time"
 )
 
-// cache 并发缓存，对核心lru进行封装
+// cache 并发缓存，对核心lru等淘汰策略(Store)进行封装。
+// 按key的哈希值分为多个分片，每个分片有自己的锁和Store，减少多核并发访问时的锁竞争
 type cache struct {
+	shards     []*cacheShard
+	nshards    int           // 分片数目，小于等于0时为1
+	newStore   NewStoreFunc  // 为nil时使用 NewLRUStore
+	cacheBytes int64         // 总容量，平均分配给每个分片
+	stale      time.Duration // 过期后继续保留的时间，由 group 决定是否返回过期的旧值
+
+	stop chan struct{} // 关闭后台清理协程
+}
+
+// cacheShard 缓存分片
+type cacheShard struct {
 	sync.RWMutex
-	lru        *lru.Cache
+	store      Store
+	newStore   NewStoreFunc
 	cacheBytes int64
-	nhit, nget int64
-	nevict     int64 // number of evictions
+	stale      time.Duration
+	nhit, nget AtomicInt
+	nevict     AtomicInt // number of evictions
+
+	reads readBuffer // 读操作的访问记录，批量补到 store 中
+
+	// 按key的哈希值分槽记录删除次数，用于丢弃删除之前开始的加载结果
+	removals [removalSlots]uint64
+}
+
+// 每个分片记录删除次数的槽位数目，不同的key可能共用一个槽位，此时只是少缓存一次
+const removalSlots = 64
+
+func removalSlot(hash uint32) uint32 {
+	return (hash >> 8) % removalSlots
+}
+
+// init 创建分片，在使用缓存之前调用
+func (c *cache) init() {
+	n := c.nshards
+	if n <= 0 {
+		n = 1
+	}
+	newStore := c.newStore
+	if newStore == nil {
+		newStore = NewLRUStore
+	}
+	shardBytes := c.cacheBytes / int64(n)
+	if c.cacheBytes > 0 && shardBytes == 0 {
+		shardBytes = 1
+	}
+
+	c.shards = make([]*cacheShard, n)
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{newStore: newStore, cacheBytes: shardBytes, stale: c.stale}
+	}
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
+
+// shard 按key的哈希值选择分片
+func (c *cache) shard(hash uint32) *cacheShard {
+	return c.shards[hash%uint32(len(c.shards))]
 }
 
 // add 添加缓存
 func (c *cache) add(key string, val ByteView) {
-	c.Lock()
-	defer c.Unlock()
+	if c.shards != nil {
+		c.shard(hashKey(key)).add(key, val)
+	}
+}
+
+// generation 返回key所在槽位的删除次数，在开始加载之前调用，加载结果通过 addIfCurrent 添加
+func (c *cache) generation(key string) uint64 {
+	if c.shards == nil {
+		return 0
+	}
+	hash := hashKey(key)
+	return c.shard(hash).generation(hash)
+}
+
+// addIfCurrent 添加加载的缓存，加载开始之后key被删除过时丢弃，
+// 避免删除之前读到的旧值在删除之后被重新写入缓存
+func (c *cache) addIfCurrent(key string, val ByteView, gen uint64) {
+	if c.shards != nil {
+		hash := hashKey(key)
+		c.shard(hash).addIfCurrent(hash, key, val, gen)
+	}
+}
+
+// 获取缓存
+func (c *cache) get(key string) (val ByteView, ok bool) {
+	if c.shards == nil {
+		return
+	}
+	hash := hashKey(key)
+	return c.shard(hash).get(hash, key)
+}
+
+// CacheStats 单个缓存的统计信息
+type CacheStats struct {
+	Bytes     int64 // 已使用的内存
+	Items     int64 // 缓存数目
+	Gets      int64 // 查找次数
+	Hits      int64 // 命中次数
+	Evictions int64 // 淘汰次数
+}
+
+// CacheType group中缓存的类型
+type CacheType int
+
+const (
+	// MainCache 缓存本节点负责的key
+	MainCache CacheType = iota + 1
+	// HotCache 缓存其他节点负责的热点key
+	HotCache
+)
+
+// stats 汇总所有分片的统计信息
+func (c *cache) stats() CacheStats {
+	var s CacheStats
+	for _, shard := range c.shards {
+		ss := shard.stats()
+		s.Bytes += ss.Bytes
+		s.Items += ss.Items
+		s.Gets += ss.Gets
+		s.Hits += ss.Hits
+		s.Evictions += ss.Evictions
+	}
+	return s
+}
+
+// remove 删除缓存
+func (c *cache) remove(key string) {
+	if c.shards != nil {
+		hash := hashKey(key)
+		c.shard(hash).remove(hash, key)
+	}
+}
+
+// removeExpired 删除所有已过期的缓存，逐个分片加锁清理
+func (c *cache) removeExpired() int {
+	n := 0
+	for _, shard := range c.shards {
+		n += shard.removeExpired()
+	}
+	return n
+}
+
+// startJanitor 开启后台协程，每隔interval清理一次过期缓存
+func (c *cache) startJanitor(interval time.Duration) {
+	c.stop = make(chan struct{})
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				c.removeExpired()
+			case <-stop:
+				return
+			}
+		}
+	}(c.stop)
+}
 
+// stopJanitor 关闭后台清理协程
+func (c *cache) stopJanitor() {
+	if c.stop != nil {
+		close(c.stop)
+		c.stop = nil
+	}
+}
+
+func (s *cacheShard) add(key string, val ByteView) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.addLocked(key, val)
+}
+
+func (s *cacheShard) generation(hash uint32) uint64 {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.removals[removalSlot(hash)]
+}
+
+func (s *cacheShard) addIfCurrent(hash uint32, key string, val ByteView, gen uint64) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.removals[removalSlot(hash)] != gen {
+		return
+	}
+	s.addLocked(key, val)
+}
+
+// addLocked 添加缓存，需要持有写锁
+func (s *cacheShard) addLocked(key string, val ByteView) {
 	// 延迟初始化(Lazy Initialization)，一个对象的延迟初始化意味
 	// 着该对象的创建将会延迟至第一次使用该对象时。主要用于提高性能，并减少程序内存要求。
-	if c.lru == nil {
-		c.lru = lru.New(&lru.CacheConfig{
-			MaxBytes: c.cacheBytes,
-			OnEvicted: func(s string, value lru.Value) {
-				c.nevict++
+	if s.store == nil {
+		s.store = s.newStore(&StoreConfig{
+			MaxBytes: s.cacheBytes,
+			OnEvicted: func(string, ByteView) {
+				s.nevict.Add(1)
 			},
 		})
 	}
-	c.lru.Add(key, val)
-	c.cacheBytes += int64(val.Len())
+
+	// 添加缓存可能触发淘汰，先补上读缓冲中的访问记录，使淘汰的依据尽量准确
+	s.applyReads(s.reads.flush())
+
+	var ttl time.Duration
+	if !val.e.IsZero() {
+		ttl = time.Until(val.e)
+		if ttl <= 0 {
+			return // 已经过期，无需缓存
+		}
+		if !val.notFound {
+			ttl += s.stale
+		}
+	}
+	s.store.Add(key, val, ttl)
 }
 
-// 获取缓存
-func (c *cache) get(key string) (val ByteView, ok bool) {
-	c.RLock()
-	defer c.RUnlock()
+// get 在读锁下调用 store.Peek，访问记录写入读缓冲，缓冲满时再加写锁补到 store 中
+func (s *cacheShard) get(hash uint32, key string) (val ByteView, ok bool) {
+	s.RLock()
+	if s.store == nil {
+		s.RUnlock()
+		return
+	}
+	val, ok = s.store.Peek(key)
+	s.RUnlock()
 
-	if c.lru == nil {
+	s.nget.Add(1)
+	// 未命中也要记录，TinyLFU 等策略依赖所有key的访问频率
+	if keys := s.reads.push(hash, key); keys != nil {
+		s.drain(keys)
+	}
+	if !ok {
 		return
 	}
+	// 自定义的 Store 可能不支持过期时间，在这里惰性删除
+	if s.dead(val, time.Now()) {
+		s.removeIfExpired(key)
+		return ByteView{}, false
+	}
+	s.nhit.Add(1) // 命中返回true
+	return val, true
+}
+
+// drain 加写锁，将读缓冲中的访问记录补到 store 中
+func (s *cacheShard) drain(keys []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.applyReads(keys)
+}
+
+// applyReads 将访问记录补到 store 中，需要持有写锁
+func (s *cacheShard) applyReads(keys []string) {
+	if toucher, ok := s.store.(Toucher); ok {
+		for _, key := range keys {
+			toucher.Touch(key)
+		}
+		return
+	}
+	for _, key := range keys {
+		// Get 同时会惰性删除过期缓存
+		s.store.Get(key)
+	}
+}
+
+// removeIfExpired 加写锁后重新检查，避免删除其他协程刚刚添加的新缓存
+func (s *cacheShard) removeIfExpired(key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if v, ok := s.store.Peek(key); ok && s.dead(v, time.Now()) {
+		s.store.Remove(key)
+	}
+}
+
+// dead 判断缓存是否已经超过保留时间。过期但仍在保留时间内的缓存照常返回，
+// 空值缓存不保留
+func (s *cacheShard) dead(val ByteView, now time.Time) bool {
+	if val.notFound {
+		return val.expired(now)
+	}
+	return val.expired(now.Add(-s.stale))
+}
+
+func (s *cacheShard) stats() CacheStats {
+	s.RLock()
+	defer s.RUnlock()
+
+	cs := CacheStats{
+		Gets:      s.nget.Get(),
+		Hits:      s.nhit.Get(),
+		Evictions: s.nevict.Get(),
+	}
+	if s.store != nil {
+		cs.Bytes = s.store.Bytes()
+		cs.Items = int64(s.store.Len())
+	}
+	return cs
+}
+
+func (s *cacheShard) remove(hash uint32, key string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.removals[removalSlot(hash)]++
+	if s.store != nil {
+		s.store.Remove(key)
+	}
+}
+
+func (s *cacheShard) removeExpired() int {
+	s.Lock()
+	defer s.Unlock()
 
-	c.nget++
-	if v, hit := c.lru.Get(key); hit {
-		c.nhit++ // 命中返回true
-		return v.(ByteView), hit
+	if expirer, ok := s.store.(Expirer); ok {
+		return expirer.RemoveExpired()
 	}
-	return
+	return 0
 }
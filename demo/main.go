@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/devhg/gocache"
 )
@@ -25,8 +26,8 @@ func createGroup() *gocache.Group {
 				return []byte(v), nil
 			}
 			log.Println("[SlowDB] key is not exist", key)
-			return nil, fmt.Errorf("%s not exist", key)
-		}))
+			return nil, fmt.Errorf("%s: %w", key, gocache.ErrNotFound)
+		}), gocache.WithNegativeTTL(10*time.Second))
 }
 
 // startCacheServer 开启一个缓存服务
@@ -38,8 +39,13 @@ func startCacheServer(addr string, addrs []string, group *gocache.Group) {
 	// 注册节点选择器 到group
 	group.RegisterPicker(pool)
 
+	// 与 HTTPPool 一起挂载 Prometheus 指标
+	mux := http.NewServeMux()
+	mux.Handle("/_cache/", pool)
+	mux.Handle("/metrics", pool.MetricsHandler())
+
 	log.Println("gocache is running at", addr)
-	log.Fatal(http.ListenAndServe(addr[7:], pool))
+	log.Fatal(http.ListenAndServe(addr[7:], mux))
 }
 
 // startAPIServer 创建一个对外的 REST Full API 服务
@@ -62,7 +68,7 @@ func startAPIServer(apiAddr string, group *gocache.Group) {
 
 	http.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
 		key := r.URL.Query().Get("key")
-		byteView, err := group.Get(key)
+		byteView, err := group.GetContext(r.Context(), key)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 		}
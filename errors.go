@@ -0,0 +1,7 @@
+package gocache
+
+import "errors"
+
+// ErrNotFound DataGetter 在key不存在时返回该错误(或包装了该错误)，
+// group 会将其作为空值短暂缓存起来，防止缓存穿透
+var ErrNotFound = errors.New("gocache: key not found")
@@ -0,0 +1,212 @@
+package gocache
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/devhg/gocache/bloom"
+)
+
+// KeysFunc 返回数据源中全部的key，用于重建布隆过滤器
+type KeysFunc func() ([]string, error)
+
+// FilterStats 布隆过滤器的统计信息
+type FilterStats struct {
+	Keys            uint64  // 过滤器中的key数目
+	Passed          int64   // 通过过滤器的请求数
+	Rejected        int64   // 被过滤器拦截的请求数
+	FalsePositives  int64   // 通过了过滤器但key不存在的请求数
+	FalsePosRate    float64 // 实际误判率 FalsePositives / (Rejected + FalsePositives)
+	EstimatedFPRate float64 // 根据key数目估算的理论误判率
+}
+
+// keyFilter 在 Group.load 之前拦截一定不存在的key，防止缓存穿透
+type keyFilter struct {
+	mu     sync.RWMutex
+	filter *bloom.Filter
+
+	// 重建期间添加的key，替换过滤器时补到新的过滤器中，否则会被重建丢掉
+	rebuilding int // 正在进行的重建数目，由 mu 保护
+	pendingMu  sync.Mutex
+	pending    []string
+
+	expected uint    // 预计key数目
+	fpRate   float64 // 期望误判率
+
+	rebuildInterval time.Duration
+	keys            KeysFunc
+	stop            chan struct{}
+
+	passed, rejected, falsePositives AtomicInt
+}
+
+// init 创建过滤器，配置了KeysFunc时先同步构建一次，再开启后台定期重建
+func (f *keyFilter) init() {
+	f.filter = bloom.New(f.expected, f.fpRate)
+	if f.keys == nil {
+		return
+	}
+	f.reload()
+	if f.rebuildInterval > 0 {
+		f.startRebuild()
+	}
+}
+
+// allow 判断key是否可能存在
+func (f *keyFilter) allow(key string) bool {
+	f.mu.RLock()
+	ok := f.filter.TestString(key)
+	f.mu.RUnlock()
+
+	if ok {
+		f.passed.Add(1)
+	} else {
+		f.rejected.Add(1)
+	}
+	return ok
+}
+
+// add 向过滤器中添加key
+func (f *keyFilter) add(keys ...string) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, key := range keys {
+		f.filter.AddString(key)
+	}
+	if f.rebuilding > 0 {
+		f.pendingMu.Lock()
+		f.pending = append(f.pending, keys...)
+		f.pendingMu.Unlock()
+	}
+}
+
+// beginRebuild 开始记录重建期间添加的key，在获取全量key之前调用
+func (f *keyFilter) beginRebuild() {
+	f.mu.Lock()
+	f.rebuilding++
+	f.mu.Unlock()
+}
+
+// finishRebuild 补上重建期间添加的key后替换过滤器，filter 为nil时只结束记录
+func (f *keyFilter) finishRebuild(filter *bloom.Filter) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// 持有写锁，没有正在进行的 add，pending 是完整的
+	if filter != nil {
+		for _, key := range f.pending {
+			filter.AddString(key)
+		}
+		f.filter = filter
+	}
+	f.rebuilding--
+	if f.rebuilding == 0 {
+		f.pending = nil
+	}
+}
+
+// rebuild 使用全量key重建过滤器，重建完成后再替换，不影响正在进行的请求
+func (f *keyFilter) rebuild(keys []string) {
+	f.beginRebuild()
+	f.finishRebuild(f.build(keys))
+}
+
+// build 使用全量key创建新的过滤器
+func (f *keyFilter) build(keys []string) *bloom.Filter {
+	n := f.expected
+	if uint(len(keys)) > n {
+		n = uint(len(keys))
+	}
+	filter := bloom.New(n, f.fpRate)
+	for _, key := range keys {
+		filter.AddString(key)
+	}
+	return filter
+}
+
+// reload 调用KeysFunc获取全量key并重建过滤器。
+// 获取全量key之后、替换过滤器之前添加的key不在全量key中，由 pending 补上
+func (f *keyFilter) reload() {
+	f.beginRebuild()
+	keys, err := f.keys()
+	if err != nil {
+		log.Println("[goCache] Failed to rebuild bloom filter", err)
+		f.finishRebuild(nil)
+		return
+	}
+	f.finishRebuild(f.build(keys))
+}
+
+// startRebuild 开启后台协程，每隔rebuildInterval重建一次过滤器
+func (f *keyFilter) startRebuild() {
+	f.stop = make(chan struct{})
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(f.rebuildInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				f.reload()
+			case <-stop:
+				return
+			}
+		}
+	}(f.stop)
+}
+
+// stopRebuild 关闭后台重建协程
+func (f *keyFilter) stopRebuild() {
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+func (f *keyFilter) stats() FilterStats {
+	f.mu.RLock()
+	filter := f.filter
+	f.mu.RUnlock()
+
+	s := FilterStats{
+		Keys:            filter.Count(),
+		Passed:          f.passed.Get(),
+		Rejected:        f.rejected.Get(),
+		FalsePositives:  f.falsePositives.Get(),
+		EstimatedFPRate: filter.EstimatedFPRate(),
+	}
+	if absent := s.Rejected + s.FalsePositives; absent > 0 {
+		s.FalsePosRate = float64(s.FalsePositives) / float64(absent)
+	}
+	return s
+}
+
+// keyFilter 返回group的布隆过滤器，不存在时创建
+func (g *Group) keyFilter() *keyFilter {
+	if g.filter == nil {
+		g.filter = &keyFilter{}
+	}
+	return g.filter
+}
+
+// AddKeys 向group的布隆过滤器中批量添加key，没有开启布隆过滤器时不做任何处理
+func (g *Group) AddKeys(keys ...string) {
+	if g.filter != nil {
+		g.filter.add(keys...)
+	}
+}
+
+// RebuildFilter 使用全量key重建group的布隆过滤器
+func (g *Group) RebuildFilter(keys []string) {
+	if g.filter != nil {
+		g.filter.rebuild(keys)
+	}
+}
+
+// FilterStats 返回布隆过滤器的统计信息
+func (g *Group) FilterStats() FilterStats {
+	if g.filter == nil {
+		return FilterStats{}
+	}
+	return g.filter.stats()
+}
@@ -1,6 +1,6 @@
 module github.com/devhg/gocache
 
-go 1.13
+go 1.18
 
 require (
 	github.com/golang/protobuf v1.4.3
@@ -1,9 +1,13 @@
 package gocache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 
 	pb "github.com/devhg/gocache/gocachepb"
 	"github.com/devhg/gocache/singlereq"
@@ -25,6 +29,77 @@ func (g GetterFunc) Get(key string) ([]byte, error) {
 	return g(key)
 }
 
+// ContextGetter 可选接口，DataGetter 同时实现该接口时，
+// 使用 Group.GetContext 传入的 context 加载数据，用于传递超时、取消以及请求相关的值
+type ContextGetter interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// A ContextGetterFunc implements DataGetter and ContextGetter with a function.
+type ContextGetterFunc func(context.Context, string) ([]byte, error)
+
+// Get implements DataGetter interface function
+func (f ContextGetterFunc) Get(key string) ([]byte, error) {
+	return f(context.Background(), key)
+}
+
+// GetContext implements ContextGetter interface function
+func (f ContextGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// TTLGetter 可选接口，DataGetter 同时实现该接口时，
+// 可以为每个key单独指定过期时间，ttl<=0 时使用group的默认过期时间
+type TTLGetter interface {
+	GetWithTTL(key string) ([]byte, time.Duration, error)
+}
+
+// A TTLGetterFunc implements DataGetter and TTLGetter with a function.
+type TTLGetterFunc func(string) ([]byte, time.Duration, error)
+
+// Get implements DataGetter interface function
+func (f TTLGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(key)
+	return b, err
+}
+
+// GetWithTTL implements TTLGetter interface function
+func (f TTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(key)
+}
+
+// ContextTTLGetter 可选接口，同时需要 ctx 和每个key单独的过期时间时实现。
+// 优先级：ContextTTLGetter > ContextGetter > TTLGetter > DataGetter，
+// 同时实现 ContextGetter 和 TTLGetter 的 DataGetter 只会收到 ctx，需要过期时间时实现该接口
+type ContextTTLGetter interface {
+	GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error)
+}
+
+// A ContextTTLGetterFunc implements DataGetter, ContextGetter, TTLGetter and ContextTTLGetter with a function.
+type ContextTTLGetterFunc func(context.Context, string) ([]byte, time.Duration, error)
+
+// Get implements DataGetter interface function
+func (f ContextTTLGetterFunc) Get(key string) ([]byte, error) {
+	b, _, err := f(context.Background(), key)
+	return b, err
+}
+
+// GetContext implements ContextGetter interface function
+func (f ContextTTLGetterFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	b, _, err := f(ctx, key)
+	return b, err
+}
+
+// GetWithTTL implements TTLGetter interface function
+func (f ContextTTLGetterFunc) GetWithTTL(key string) ([]byte, time.Duration, error) {
+	return f(context.Background(), key)
+}
+
+// GetContextWithTTL implements ContextTTLGetter interface function
+func (f ContextTTLGetterFunc) GetContextWithTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	return f(ctx, key)
+}
+
 // 一个group可以被认为一个缓存的命名空间
 // 每一个group拥有一个唯一的name，这样可以创建多个group
 type Group struct {
@@ -33,21 +108,58 @@ type Group struct {
 	dataGetter DataGetter // 缓存未命中时获取数据源的回调
 
 	// main cache support safe concurrent
+	// 缓存本节点负责的key
 	mainCache cache
 
+	// hotCache 缓存其他节点负责的热点key，避免每次都访问远程节点
+	// 从远程节点获取的值按一定概率缓存到这里
+	hotCache cache
+
 	// 保证并发只会请求一次
-	singleReq *singlereq.ReqGroup
+	singleReq *singlereq.Group[string, ByteView]
 
 	// nodePicker 节点选择器
 	picker NodePicker
+
+	ttl             time.Duration // 缓存默认过期时间，0表示永不过期
+	cleanupInterval time.Duration // 后台清理过期缓存的间隔
+	ttlJitter       float64       // 过期时间随机抖动比例，防止缓存雪崩
+	hotCacheRatio   float64       // 热点缓存占总容量的比例
+	negativeTTL     time.Duration // 空值缓存的过期时间，防止缓存穿透
+
+	staleWhileRevalidate time.Duration // 过期后仍然返回旧值、并在后台刷新的时间
+	staleIfError         time.Duration // 过期后加载失败时仍然返回旧值的时间
+	refreshing           sync.Map      // 正在后台刷新的key
+
+	// 过期前最后 refreshAhead 比例的存活时间内被访问时提前刷新
+	refreshAhead float64
+	// 执行后台刷新的协程池，为nil时每次刷新启动一个协程
+	refresher *refresher
+
+	// 布隆过滤器，拦截一定不存在的key
+	filter *keyFilter
+
+	// 合并一段时间内的缓存未命中，批量访问数据源
+	batcher *batchLoader
+
+	// 统计信息
+	stats groupStats
 }
 
+const (
+	// 从远程节点获取的值，每 hotCacheSampleRate 次缓存一次到热点缓存
+	hotCacheSampleRate = 10
+	// 热点缓存的最长存活时间。负责节点上的值更新后本节点收不到通知，
+	// 没有过期时间或者过期时间太远的副本最多保留这么久
+	hotCacheMaxTTL = time.Minute
+)
+
 var (
 	mu     sync.RWMutex
 	groups = make(map[string]*Group)
 )
 
-func NewGroup(name string, cacheBytes int64, getter DataGetter) *Group {
+func NewGroup(name string, cacheBytes int64, getter DataGetter, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("dataGetter is needed")
 	}
@@ -55,14 +167,62 @@ func NewGroup(name string, cacheBytes int64, getter DataGetter) *Group {
 	defer mu.Unlock()
 	g := &Group{
 		name:       name,
-		mainCache:  cache{cacheBytes: cacheBytes},
+		cacheBytes: cacheBytes,
 		dataGetter: getter,
-		singleReq:  &singlereq.ReqGroup{},
+		singleReq:  &singlereq.Group[string, ByteView]{},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	// 从总容量中划分出热点缓存的容量
+	hotBytes := int64(float64(cacheBytes) * g.hotCacheRatio)
+	g.mainCache.cacheBytes = cacheBytes - hotBytes
+	// 过期的缓存需要保留到两个窗口都结束。热点缓存不保留，过期后重新访问远程节点
+	g.mainCache.stale = g.staleWhileRevalidate
+	if g.staleIfError > g.mainCache.stale {
+		g.mainCache.stale = g.staleIfError
+	}
+	g.hotCache.cacheBytes = hotBytes
+	g.mainCache.init()
+	g.hotCache.init()
+
+	if g.cleanupInterval == 0 && g.ttl > 0 {
+		g.cleanupInterval = defaultCleanupInterval
+	}
+	if g.cleanupInterval > 0 {
+		g.mainCache.startJanitor(g.cleanupInterval)
+		if hotBytes > 0 {
+			g.hotCache.startJanitor(g.cleanupInterval)
+		}
+	}
+	if g.filter != nil {
+		g.filter.init()
+	}
+	if g.refresher != nil {
+		g.refresher.start(g.reload)
+	}
+
+	// 同名group被覆盖时，关闭旧group的后台协程
+	if old, ok := groups[name]; ok {
+		old.close()
 	}
 	groups[name] = g
 	return groups[name]
 }
 
+// close 关闭group的后台协程
+func (g *Group) close() {
+	g.mainCache.stopJanitor()
+	g.hotCache.stopJanitor()
+	if g.filter != nil {
+		g.filter.stopRebuild()
+	}
+	if g.refresher != nil {
+		g.refresher.stop()
+	}
+}
+
 func GetGroup(name string) *Group {
 	// 共享锁
 	mu.RLock()
@@ -71,57 +231,323 @@ func GetGroup(name string) *Group {
 	return g
 }
 
+// Get 等价于 GetContext(context.Background(), key)
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 获取key的缓存值，ctx 会传递给远程节点请求以及 ContextGetter
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	g.stats.gets.Add(1)
+	return g.lookup(ctx, key)
+}
 
+// lookup 依次在本机缓存、远程节点和数据源中查找
+func (g *Group) lookup(ctx context.Context, key string) (ByteView, error) {
 	// 在本机缓存中查找
-	if byteView, ok := g.mainCache.get(key); ok {
+	byteView, cached := g.lookupCache(key)
+	if cached && g.cacheHit(key, byteView, time.Now()) {
+		g.stats.cacheHits.Add(1)
 		log.Printf("read from local cache %p", &byteView)
+		if byteView.notFound {
+			return ByteView{}, ErrNotFound
+		}
 		return byteView, nil
 	}
 
+	// 本机没有缓存时，由布隆过滤器拦截一定不存在的key，已经缓存的key不经过过滤器
+	filtered := g.filter != nil && !cached
+	if filtered && !g.filter.allow(key) {
+		return ByteView{}, ErrNotFound
+	}
+
 	// 去其他节点查找或者从数据库从新缓存
-	return g.load(key)
+	val, err := g.load(ctx, key)
+	if filtered && errors.Is(err, ErrNotFound) {
+		g.filter.falsePositives.Add(1)
+	}
+	if err != nil && cached && g.staleOnError(key, byteView, err) {
+		return byteView, nil
+	}
+	return val, err
+}
+
+// cacheHit 判断本机缓存中的值能否直接返回：没有过期，或者过期后仍在 stale-while-revalidate 窗口内。
+// 返回过期的旧值，或者缓存即将过期时在后台刷新
+func (g *Group) cacheHit(key string, val ByteView, now time.Time) bool {
+	if !val.expired(now) {
+		if g.refreshDue(val, now) && g.refresh(key) {
+			g.stats.refreshes.Add(1)
+		}
+		return true
+	}
+	if !val.staleWithin(g.staleWhileRevalidate, now) {
+		return false
+	}
+	g.stats.staleHits.Add(1)
+	g.refresh(key)
+	return true
+}
+
+// refreshDue 判断缓存是否进入了过期前最后 refreshAhead 比例的存活时间
+func (g *Group) refreshDue(val ByteView, now time.Time) bool {
+	if g.refreshAhead <= 0 || val.ttl <= 0 {
+		return false
+	}
+	return val.e.Sub(now) < time.Duration(g.refreshAhead*float64(val.ttl))
 }
 
-func (g *Group) load(key string) (byteView ByteView, err error) {
+// staleOnError 加载失败时，判断能否用过期不超过 stale-if-error 窗口的旧值代替错误返回
+func (g *Group) staleOnError(key string, stale ByteView, err error) bool {
+	if errors.Is(err, ErrNotFound) || !stale.staleWithin(g.staleIfError, time.Now()) {
+		return false
+	}
+	g.stats.staleErrors.Add(1)
+	log.Println("[goCache] Serving stale value of", key, "after error:", err)
+	return true
+}
+
+// refresh 在后台重新加载key，返回是否开始了新的刷新。加载与前台的 Get 共享 singlereq，
+// refreshing 保证每个key同时只有一次刷新，不会让每次访问都加入等待。
+// 设置了 WithRefreshAhead 时交给协程池执行，队列已满时放弃，之后的访问会再次触发刷新
+func (g *Group) refresh(key string) bool {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return false
+	}
+	if g.refresher == nil {
+		go g.reload(key)
+		return true
+	}
+	if !g.refresher.submit(key) {
+		g.refreshing.Delete(key)
+		g.stats.refreshDrops.Add(1)
+		return false
+	}
+	return true
+}
+
+// reload 重新加载key，加载失败已经记录过日志，旧值保留到过期或者窗口结束
+func (g *Group) reload(key string) {
+	defer g.refreshing.Delete(key)
+	_, _, _ = g.loadShared(context.Background(), key)
+}
+
+// lookupCache 依次在主缓存和热点缓存中查找
+func (g *Group) lookupCache(key string) (ByteView, bool) {
+	if byteView, ok := g.mainCache.get(key); ok {
+		return byteView, ok
+	}
+	if g.hotCache.cacheBytes <= 0 {
+		return ByteView{}, false
+	}
+	return g.hotCache.get(key)
+}
+
+// load 的调用方共享同一次加载，加载使用第一个调用方的ctx。
+// 其他调用方的ctx被取消时放弃等待，不影响进行中的加载
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
+	g.stats.loads.Add(1)
+	if err := ctx.Err(); err != nil {
+		return ByteView{}, err
+	}
+	val, err, shared := g.loadShared(ctx, key)
+	// 共享的加载因为第一个调用方的ctx被取消而失败，自己的ctx仍然有效时重新加载一次
+	if shared && isContextErr(err) && ctx.Err() == nil {
+		val, err, _ = g.loadShared(ctx, key)
+	}
+	if err != nil {
+		return ByteView{}, err
+	}
+	return val, nil
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// abandoned 远程节点请求因为ctx被取消或超时而失败时，返回对应的错误。
+// 此时不应该改为从本地数据源加载，否则会在调用方放弃之后访问数据源并缓存结果
+func abandoned(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if isContextErr(err) {
+		return err
+	}
+	return nil
+}
+
+// loadShared 通过 singlereq 加载，shared 表示是否与其他调用方共享了同一次加载
+func (g *Group) loadShared(ctx context.Context, key string) (ByteView, error, bool) {
 	// 每一个key只允许请求一次远程服务器或者db  防止缓存击穿
-	val, err := g.singleReq.Do(key, func() (i interface{}, err error) {
+	return g.singleReq.DoContext(ctx, key, func() (ByteView, error) {
+		g.stats.loadsDeduped.Add(1)
+		// DataGetter panic 时记录一次，再交给 singlereq 转换为 *singlereq.PanicError 返回给所有调用方
+		defer func() {
+			if r := recover(); r != nil {
+				g.stats.loadErrors.Add(1)
+				log.Println("[goCache] Panic while loading", key, r)
+				panic(r)
+			}
+		}()
 		if g.picker != nil {
 			if nodeGetter, ok := g.picker.PickNode(key); ok {
-				if byteView, err = g.getFromNode(nodeGetter, key); err == nil {
+				byteView, err := g.getFromNode(ctx, nodeGetter, key)
+				if err == nil {
+					g.stats.peerLoads.Add(1)
 					return byteView, nil
 				}
+				// 远程节点确认key不存在，无需再访问本地数据源
+				if errors.Is(err, ErrNotFound) {
+					g.stats.peerLoads.Add(1)
+					return ByteView{}, err
+				}
+				// 调用方已经放弃等待，不再访问本地数据源
+				if err := abandoned(ctx, err); err != nil {
+					return ByteView{}, err
+				}
+				g.stats.peerErrors.Add(1)
 				log.Println("[goCache] Failed to get from other node", err)
 			}
 		}
-		return g.getLocally(key)
+		return g.getLocally(ctx, key)
 	})
-	if err == nil {
-		return val.(ByteView), nil
-	}
-	return
 }
 
 // getLocally 从自定义的回调函数中获取缓存中没有的资源
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.dataGetter.Get(key)
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	var (
+		bytes []byte
+		ttl   time.Duration
+		err   error
+		// 加载期间key被删除时，读到的可能是删除之前的值，不能再缓存
+		gen = g.mainCache.generation(key)
+	)
+	if g.batcher != nil {
+		// 与其他并发的未命中合并为一次批量加载
+		bytes, err = g.batcher.load(ctx, key)
+	} else {
+		// ctx 优先于过期时间，见 ContextTTLGetter
+		switch getter := g.dataGetter.(type) {
+		case ContextTTLGetter:
+			bytes, ttl, err = getter.GetContextWithTTL(ctx, key)
+		case ContextGetter:
+			bytes, err = getter.GetContext(ctx, key)
+		case TTLGetter:
+			bytes, ttl, err = getter.GetWithTTL(key)
+		default:
+			bytes, err = g.dataGetter.Get(key)
+		}
+	}
 	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			g.stats.localLoads.Add(1)
+			g.populateNotFound(key, gen)
+			return ByteView{}, ErrNotFound
+		}
+		g.stats.loadErrors.Add(1)
 		log.Println("[goCache] Failed to get from dataSource", err)
 		return ByteView{}, err
 	}
-	byteView := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, byteView)
+	g.stats.localLoads.Add(1)
+	byteView := g.newByteView(bytes, ttl)
+	g.populateCache(key, byteView, gen)
 	return byteView, nil
 }
 
-// 缓存到当前节点的group
-func (g *Group) populateCache(key string, val ByteView) {
+// newByteView 复制b并计算过期时间，ttl<=0 时使用group的默认过期时间
+func (g *Group) newByteView(b []byte, ttl time.Duration) ByteView {
+	if ttl <= 0 {
+		ttl = g.ttl
+	}
+	if ttl <= 0 {
+		return ByteView{b: cloneBytes(b)}
+	}
+	ttl = g.jitter(ttl)
+	return ByteView{b: cloneBytes(b), e: time.Now().Add(ttl), ttl: ttl}
+}
+
+// jitter 在 ttl 的基础上随机增减 ttlJitter 比例的时间
+func (g *Group) jitter(ttl time.Duration) time.Duration {
+	if g.ttlJitter <= 0 {
+		return ttl
+	}
+	delta := (rand.Float64()*2 - 1) * g.ttlJitter * float64(ttl)
+	return ttl + time.Duration(delta)
+}
+
+// 缓存到当前节点的group，gen 为开始加载前 mainCache.generation 的返回值，
+// 加载期间key被删除或者被 Set 时丢弃加载的结果
+func (g *Group) populateCache(key string, val ByteView, gen uint64) {
+	g.mainCache.addIfCurrent(key, val, gen)
+}
+
+// populateNotFound 缓存一个短期的空值，防止缓存穿透
+func (g *Group) populateNotFound(key string, gen uint64) {
+	if g.negativeTTL > 0 {
+		g.populateCache(key, ByteView{e: time.Now().Add(g.negativeTTL), notFound: true}, gen)
+		return
+	}
+	// key 已经不存在，删除保留的旧值
+	if g.mainCache.stale > 0 {
+		g.mainCache.remove(key)
+	}
+}
+
+// Set 设置key的缓存值，key由一致性哈希选出的节点负责时，转发给该节点
+// 常用于数据库更新后主动刷新缓存
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	byteView := g.newByteView(value, 0)
+	if g.picker != nil {
+		if nodeGetter, ok := g.picker.PickNode(key); ok {
+			// 本机的布隆过滤器同样需要知道key存在，否则之后的 Get 不会访问负责的节点
+			g.AddKeys(key)
+			// 本机可能在远程节点失败时缓存过该key，一并删除
+			g.removeLocally(key)
+			return g.setToNode(nodeGetter, key, byteView)
+		}
+	}
+	g.setLocally(key, byteView)
+	return nil
+}
+
+// Remove 删除key的缓存，key由一致性哈希选出的节点负责时，转发给该节点
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	if g.picker != nil {
+		if nodeGetter, ok := g.picker.PickNode(key); ok {
+			return nodeGetter.Remove(&pb.Request{Group: g.name, Key: key})
+		}
+	}
+	return nil
+}
+
+// setLocally 缓存到当前节点，并将key加入布隆过滤器
+func (g *Group) setLocally(key string, val ByteView) {
+	g.AddKeys(key)
+	// 先删除，使进行中的加载结果被丢弃，不会覆盖新的值
+	g.removeLocally(key)
 	g.mainCache.add(key, val)
 }
 
+// removeLocally 删除当前节点的缓存
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	// 进行中的加载可能读到的是删除前的值：删除次数变化后加载结果不会写入缓存，
+	// Forget 使之后的 Get 重新加载，而不是等待进行中的加载
+	g.singleReq.Forget(key)
+}
+
 // 将实现了 NodePicker 接口的 节点选择器 注入到 Group 中
 func (g *Group) RegisterPicker(picker NodePicker) {
 	if g.picker != nil {
@@ -131,17 +557,53 @@ func (g *Group) RegisterPicker(picker NodePicker) {
 }
 
 // 用实现了 NodeGetter 接口访问远程节点，获取缓存值
-func (g *Group) getFromNode(getter NodeGetter, key string) (ByteView, error) {
+func (g *Group) getFromNode(ctx context.Context, getter NodeGetter, key string) (ByteView, error) {
 	request := &pb.Request{Group: g.name, Key: key}
 	response := &pb.Response{}
-	err := getter.Get(request, response)
+	gen := g.hotCache.generation(key)
+	err := getter.Get(ctx, request, response)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: response.Value}, nil
+	byteView := ByteView{b: response.Value, e: expireFromUnixNano(response.Expire)}
+	g.sampleHotCache(key, byteView, gen)
+	return byteView, nil
 }
 
-// getLocally 从自定义的回调函数中获取缓存中没有的资源
+// sampleHotCache 按一定概率将远程节点的值缓存到热点缓存，热点key被多次访问后大概率会被缓存。
+// 副本最多存活 hotCacheMaxTTL。gen 为请求远程节点前 hotCache.generation 的返回值
+func (g *Group) sampleHotCache(key string, val ByteView, gen uint64) {
+	if g.hotCache.cacheBytes > 0 && rand.Intn(hotCacheSampleRate) == 0 {
+		if deadline := time.Now().Add(hotCacheMaxTTL); val.e.IsZero() || val.e.After(deadline) {
+			val.e = deadline
+		}
+		g.hotCache.addIfCurrent(key, val, gen)
+	}
+}
+
+// 用实现了 NodeGetter 接口访问远程节点，设置缓存值
+func (g *Group) setToNode(setter NodeGetter, key string, val ByteView) error {
+	return setter.Set(&pb.SetRequest{
+		Group:  g.name,
+		Key:    key,
+		Value:  val.b,
+		Expire: expireToUnixNano(val.e),
+	})
+}
+
+// CacheStats 返回group中某个缓存的统计信息
+func (g *Group) CacheStats(which CacheType) CacheStats {
+	switch which {
+	case MainCache:
+		return g.mainCache.stats()
+	case HotCache:
+		return g.hotCache.stats()
+	default:
+		return CacheStats{}
+	}
+}
+
+// GetCacheBytes 返回group的最大缓存容量
 func (g *Group) GetCacheBytes(key string) int64 {
 	return g.cacheBytes
 }
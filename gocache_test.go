@@ -1,11 +1,22 @@
 package gocache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	pb "github.com/devhg/gocache/gocachepb"
+	"github.com/devhg/gocache/singlereq"
 )
 
 func TestGetterFunc_Get(t *testing.T) {
@@ -62,3 +73,1475 @@ func TestGetGroup(t *testing.T) {
 		t.Fatalf("the value of unknow should be empty, but %s got", get)
 	}
 }
+
+func TestGroupTTL(t *testing.T) {
+	loadCounts := make(map[string]int)
+	group := NewGroup("ttl", 2<<10, TTLGetterFunc(
+		func(key string) ([]byte, time.Duration, error) {
+			loadCounts[key]++
+			if key == "long" {
+				return []byte(key), time.Hour, nil
+			}
+			return []byte(key), 0, nil
+		}), WithTTL(20*time.Millisecond))
+
+	for _, k := range []string{"short", "long"} {
+		if v, err := group.Get(k); err != nil || v.String() != k {
+			t.Fatalf("failed to get %s", k)
+		}
+	}
+	if v, _ := group.Get("short"); v.Expire().IsZero() {
+		t.Fatal("short should have an expiry")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = group.Get("short")
+	_, _ = group.Get("long")
+	if loadCounts["short"] != 2 {
+		t.Fatalf("short should be reloaded after expiry, loaded %d times", loadCounts["short"])
+	}
+	if loadCounts["long"] != 1 {
+		t.Fatalf("long should not expire, loaded %d times", loadCounts["long"])
+	}
+}
+
+func TestGroupTTLJitter(t *testing.T) {
+	g := &Group{ttl: time.Second, ttlJitter: 0.2}
+	distinct := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		d := g.jitter(g.ttl)
+		if d < 800*time.Millisecond || d > 1200*time.Millisecond {
+			t.Fatalf("jittered ttl %v out of range", d)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < 2 {
+		t.Fatal("ttl should be randomized")
+	}
+}
+
+func TestGroupStaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	group := NewGroup("stale-while-revalidate", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			n := atomic.AddInt32(&loads, 1)
+			if n > 1 {
+				<-release
+			}
+			return []byte(fmt.Sprintf("v%d", n)), nil
+		}), WithTTL(20*time.Millisecond), WithStaleWhileRevalidate(time.Hour))
+
+	if v, err := group.Get("key"); err != nil || v.String() != "v1" {
+		t.Fatalf("unexpected value %v %v", v, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// 数据源阻塞，过期的旧值仍然直接返回，并且只有一次后台刷新
+	for i := 0; i < 5; i++ {
+		if v, err := group.Get("key"); err != nil || v.String() != "v1" {
+			t.Fatalf("expected stale value v1, got %v %v", v, err)
+		}
+	}
+	if n := group.Stats().StaleHits; n != 5 {
+		t.Fatalf("expected 5 stale hits, got %d", n)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := group.Get("key"); v.String() == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale value should be refreshed in the background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("expected 2 loads, got %d", n)
+	}
+}
+
+func TestGroupStaleIfError(t *testing.T) {
+	const (
+		ok int32 = iota
+		fail
+		missing
+	)
+	var mode int32
+	group := NewGroup("stale-if-error", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			switch atomic.LoadInt32(&mode) {
+			case fail:
+				return nil, errors.New("db down")
+			case missing:
+				return nil, ErrNotFound
+			}
+			return []byte(key), nil
+		}), WithTTL(20*time.Millisecond), WithStaleIfError(time.Hour))
+
+	if _, err := group.Get("key"); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&mode, fail)
+	time.Sleep(30 * time.Millisecond)
+
+	// 加载失败时返回过期的旧值
+	if v, err := group.Get("key"); err != nil || v.String() != "key" {
+		t.Fatalf("expected stale value, got %v %v", v, err)
+	}
+	if views, err := group.GetMulti(context.Background(), []string{"key"}); err != nil || views["key"].String() != "key" {
+		t.Fatalf("expected stale value from GetMulti, got %v %v", views, err)
+	}
+	if s := group.Stats(); s.StaleErrors != 2 || s.StaleHits != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+
+	// key 已经不存在时不返回旧值，旧值被删除
+	atomic.StoreInt32(&mode, missing)
+	if _, err := group.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	atomic.StoreInt32(&mode, fail)
+	if _, err := group.Get("key"); err == nil {
+		t.Fatal("stale value should be removed after ErrNotFound")
+	}
+}
+
+func TestGroupRefreshAhead(t *testing.T) {
+	var loads int32
+	group := NewGroup("refresh-ahead", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(fmt.Sprintf("v%d", atomic.AddInt32(&loads, 1))), nil
+		}), WithTTL(200*time.Millisecond), WithRefreshAhead(0.5, 1))
+
+	if v, _ := group.Get("key"); v.String() != "v1" {
+		t.Fatalf("unexpected value %v", v)
+	}
+	// 进入最后 50% 的存活时间，返回当前的值并在后台刷新
+	time.Sleep(120 * time.Millisecond)
+	if v, _ := group.Get("key"); v.String() != "v1" {
+		t.Fatalf("unexpected value %v", v)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if v, _ := group.Get("key"); v.String() == "v2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("entry should be refreshed before it expires")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := group.Stats(); s.Loads != 1 || s.Refreshes != 1 {
+		t.Fatalf("refresh should not cause a miss, stats %+v", s)
+	}
+}
+
+func TestGroupRefreshAheadWorkers(t *testing.T) {
+	const workers = 2
+	var (
+		mu            sync.Mutex
+		running, peak int
+		refreshed     = make(map[string]bool)
+		release       = make(chan struct{})
+		loading       = false
+	)
+	group := NewGroup("refresh-workers", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			if !loading {
+				mu.Unlock()
+				return []byte(key), nil
+			}
+			running++
+			if running > peak {
+				peak = running
+			}
+			mu.Unlock()
+
+			<-release
+			mu.Lock()
+			running--
+			refreshed[key] = true
+			mu.Unlock()
+			return []byte(key), nil
+		}), WithTTL(time.Second), WithRefreshAhead(0.9, workers))
+
+	keys := []string{"a", "b", "c", "d", "e", "f"}
+	for _, k := range keys {
+		_, _ = group.Get(k)
+	}
+	mu.Lock()
+	loading = true
+	mu.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	for _, k := range keys {
+		_, _ = group.Get(k)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(refreshed)
+		mu.Unlock()
+		if n == len(keys) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d keys to be refreshed, got %d", len(keys), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if peak > workers {
+		t.Fatalf("at most %d refreshes should run at once, got %d", workers, peak)
+	}
+}
+
+func TestGroupNegativeCache(t *testing.T) {
+	loads := 0
+	group := NewGroup("negative", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+		}), WithNegativeTTL(20*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		if _, err := group.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("missing key should be cached, loaded %d times", loads)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	_, _ = group.Get("unknown")
+	if loads != 2 {
+		t.Fatalf("negative entry should expire, loaded %d times", loads)
+	}
+
+	// 远程节点通过 notFoundHeader 返回 ErrNotFound
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	err := getter.Get(context.Background(), &pb.Request{Group: "negative", Key: "unknown"}, &pb.Response{})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from peer, got %v", err)
+	}
+	if loads != 2 {
+		t.Fatalf("peer request should hit negative cache, loaded %d times", loads)
+	}
+
+	// 代理或者旧版本节点返回的普通 404 不能当作key不存在
+	proxy := httptest.NewServer(http.NotFoundHandler())
+	defer proxy.Close()
+	getter = &httpGetter{baseURL: proxy.URL + defaultBasePath}
+	err = getter.Get(context.Background(), &pb.Request{Group: "negative", Key: "unknown"}, &pb.Response{})
+	if err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("plain 404 should not be ErrNotFound, got %v", err)
+	}
+}
+
+func TestGroupBloomFilter(t *testing.T) {
+	loads := 0
+	group := NewGroup("bloom", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}),
+		WithBloomFilter(100, 0.01),
+		WithBloomFilterRebuild(0, func() ([]string, error) {
+			return []string{"A", "B"}, nil
+		}))
+
+	if v, err := group.Get("A"); err != nil || v.String() != "1" {
+		t.Fatal("failed to get A")
+	}
+	// C 存在于数据源但不在过滤器中
+	if _, err := group.Get("C"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("C should be rejected by filter, got %v", err)
+	}
+	if loads != 1 {
+		t.Fatalf("rejected keys should not be loaded, loaded %d times", loads)
+	}
+
+	group.AddKeys("C", "D")
+	if v, err := group.Get("C"); err != nil || v.String() != "3" {
+		t.Fatal("failed to get C after AddKeys")
+	}
+	if _, err := group.Get("D"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for D, got %v", err)
+	}
+
+	stats := group.FilterStats()
+	if stats.Rejected != 1 || stats.FalsePositives != 1 || stats.Passed != 3 {
+		t.Fatalf("unexpected filter stats %+v", stats)
+	}
+
+	group.RebuildFilter([]string{"A"})
+	if _, err := group.Get("B"); !errors.Is(err, ErrNotFound) {
+		t.Fatal("B should be rejected after rebuild")
+	}
+
+	// 已经缓存的key不经过过滤器
+	group.RebuildFilter(nil)
+	if v, err := group.Get("A"); err != nil || v.String() != "1" {
+		t.Fatalf("cached A should bypass filter, got %v", err)
+	}
+	views, errs := group.getMulti(context.Background(), []string{"A", "C"})
+	if len(errs) != 0 || views["A"].String() != "1" || views["C"].String() != "3" {
+		t.Fatalf("cached keys should bypass filter, got %v", errs)
+	}
+	if loads != 3 {
+		t.Fatalf("cached keys should not be loaded, loaded %d times", loads)
+	}
+}
+
+// fakeNode 模拟远程节点，记录收到的请求
+type fakeNode struct {
+	sets    map[string][]byte
+	removes []string
+	gets    int
+}
+
+func (n *fakeNode) HTTPGet(group, key string) ([]byte, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func (n *fakeNode) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	n.gets++
+	v, ok := n.sets[in.GetKey()]
+	if !ok {
+		return ErrNotFound
+	}
+	out.Value = v
+	return nil
+}
+
+func (n *fakeNode) Set(in *pb.SetRequest) error {
+	n.sets[in.GetKey()] = in.GetValue()
+	return nil
+}
+
+func (n *fakeNode) Remove(in *pb.Request) error {
+	n.removes = append(n.removes, in.GetKey())
+	return nil
+}
+
+// fakePicker 以 "remote-" 开头的key由远程节点负责
+type fakePicker struct {
+	node *fakeNode
+}
+
+func (p *fakePicker) PickNode(key string) (NodeGetter, bool) {
+	if strings.HasPrefix(key, "remote-") {
+		return p.node, true
+	}
+	return nil, false
+}
+
+func TestGroupBloomFilterAddDuringRebuild(t *testing.T) {
+	var (
+		calls   int32
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	group := NewGroup("bloom-rebuild", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}),
+		WithBloomFilter(100, 0.01),
+		WithBloomFilterRebuild(0, func() ([]string, error) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				close(started)
+				<-release
+			}
+			return []string{"A"}, nil
+		}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		group.filter.reload()
+	}()
+	// 获取全量key之后添加的key不在全量key中，替换过滤器后也不能丢失
+	<-started
+	if err := group.Set("new", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	<-done
+
+	if !group.filter.allow("new") {
+		t.Fatal("key added during rebuild should be in the new filter")
+	}
+}
+
+func TestGroupSetRemove(t *testing.T) {
+	loads := 0
+	group := NewGroup("set", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte("db-" + key), nil
+		}))
+	node := &fakeNode{sets: make(map[string][]byte)}
+	group.RegisterPicker(&fakePicker{node: node})
+
+	if err := group.Set("local", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := group.Get("local"); err != nil || v.String() != "v1" {
+		t.Fatalf("expected v1, got %s %v", v, err)
+	}
+	if err := group.Set("remote-k", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if string(node.sets["remote-k"]) != "v2" {
+		t.Fatal("set should be routed to the owner node")
+	}
+	if v, err := group.Get("remote-k"); err != nil || v.String() != "v2" {
+		t.Fatalf("expected v2 from owner, got %s %v", v, err)
+	}
+	if loads != 0 {
+		t.Fatalf("set values should not be loaded, loaded %d times", loads)
+	}
+
+	if err := group.Remove("local"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := group.Get("local"); v.String() != "db-local" || loads != 1 {
+		t.Fatal("removed key should be reloaded from data source")
+	}
+	if err := group.Remove("remote-k"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(node.removes, []string{"remote-k"}) {
+		t.Fatalf("remove should be routed to the owner node, got %v", node.removes)
+	}
+}
+
+func TestGroupSetRemoteWithBloomFilter(t *testing.T) {
+	group := NewGroup("set-bloom", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}), WithBloomFilter(100, 0.01))
+	node := &fakeNode{sets: make(map[string][]byte)}
+	group.RegisterPicker(&fakePicker{node: node})
+
+	if err := group.Set("remote-k", []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	// 本机不负责该key，过滤器也不能拦截
+	if v, err := group.Get("remote-k"); err != nil || v.String() != "v" {
+		t.Fatalf("expected v from owner, got %s %v", v, err)
+	}
+	if node.gets != 1 {
+		t.Fatalf("expected 1 get on the owner, got %d", node.gets)
+	}
+}
+
+func TestGroupRemoveDuringLoad(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		value   = "old"
+		started = make(chan struct{}, 1)
+		release = make(chan struct{})
+	)
+	group := NewGroup("remove-during-load", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			mu.Lock()
+			v := value
+			mu.Unlock()
+			if v == "old" {
+				started <- struct{}{}
+				<-release
+			}
+			return []byte(v), nil
+		}))
+
+	for _, update := range []func(){
+		func() { _ = group.Remove("k") },
+		func() { _ = group.Set("k", []byte("set")) },
+	} {
+		mu.Lock()
+		value = "old"
+		mu.Unlock()
+		_ = group.Remove("k")
+
+		done := make(chan ByteView)
+		go func() {
+			v, _ := group.Get("k")
+			done <- v
+		}()
+		<-started
+		// 数据源已经更新，进行中的加载读到的是旧值
+		mu.Lock()
+		value = "new"
+		mu.Unlock()
+		update()
+		close(release)
+		if v := <-done; v.String() != "old" {
+			t.Fatalf("in-flight load should return what it read, got %s", v)
+		}
+		release = make(chan struct{})
+
+		// 旧值不能覆盖删除或者 Set 之后的结果
+		if v, _ := group.Get("k"); v.String() == "old" {
+			t.Fatal("load started before the update should not be cached")
+		}
+	}
+}
+
+func TestHTTPPoolSetRemove(t *testing.T) {
+	group := NewGroup("http-set", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return nil, ErrNotFound
+		}))
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	err := getter.Set(&pb.SetRequest{Group: "http-set", Key: "k", Value: []byte("v")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := group.Get("k"); err != nil || v.String() != "v" {
+		t.Fatalf("expected v, got %s %v", v, err)
+	}
+
+	if err = getter.Remove(&pb.Request{Group: "http-set", Key: "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := group.Get("k"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after remove, got %v", err)
+	}
+}
+
+// flakyNode 前 fails 次删除请求失败
+type flakyNode struct {
+	fakeNode
+	fails int
+}
+
+func (n *flakyNode) Remove(in *pb.Request) error {
+	if n.fails > 0 {
+		n.fails--
+		return fmt.Errorf("connection refused")
+	}
+	return n.fakeNode.Remove(in)
+}
+
+type fakeLister struct {
+	fakePicker
+	nodes map[string]NodeGetter
+}
+
+func (l *fakeLister) ListNodes() map[string]NodeGetter {
+	return l.nodes
+}
+
+func TestGroupInvalidateAll(t *testing.T) {
+	group := NewGroup("invalidate", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	ok, flaky, dead := &flakyNode{}, &flakyNode{fails: 1}, &flakyNode{fails: 10}
+	group.RegisterPicker(&fakeLister{nodes: map[string]NodeGetter{
+		"node1": ok, "node2": flaky, "node3": dead,
+	}})
+
+	results, err := group.InvalidateAll("k")
+	if err == nil {
+		t.Fatal("expected error for dead node")
+	}
+	expect := []InvalidateResult{
+		{Node: "node1", Attempts: 1},
+		{Node: "node2", Attempts: 2},
+		{Node: "node3", Attempts: invalidateAttempts, Err: results[2].Err},
+	}
+	if !reflect.DeepEqual(results, expect) || results[2].Err == nil {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	if len(ok.removes) != 1 || len(flaky.removes) != 1 {
+		t.Fatal("key should be removed from every reachable node")
+	}
+}
+
+func TestGroupInvalidateAllWithoutLister(t *testing.T) {
+	group := NewGroup("invalidate-owner", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	node := &fakeNode{sets: make(map[string][]byte)}
+	group.RegisterPicker(&fakePicker{node: node})
+
+	// 不能列出所有节点时，至少通知负责key的节点
+	results, err := group.InvalidateAll("remote-k")
+	if err != nil || len(results) != 1 || results[0].Attempts != 1 {
+		t.Fatalf("unexpected results %+v %v", results, err)
+	}
+	if !reflect.DeepEqual(node.removes, []string{"remote-k"}) {
+		t.Fatalf("owner should be notified, got %v", node.removes)
+	}
+
+	if results, err := group.InvalidateAll("local"); err != nil || len(results) != 0 {
+		t.Fatalf("unexpected results %+v %v", results, err)
+	}
+}
+
+func TestGroupHotCache(t *testing.T) {
+	group := NewGroup("hot", 1000, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithHotCache(0.2))
+	node := &fakeNode{sets: map[string][]byte{"remote-hot": []byte("v")}}
+	group.RegisterPicker(&fakePicker{node: node})
+
+	if group.mainCache.cacheBytes != 800 || group.hotCache.cacheBytes != 200 {
+		t.Fatalf("unexpected cache bytes split %d/%d",
+			group.mainCache.cacheBytes, group.hotCache.cacheBytes)
+	}
+
+	n := 200
+	for i := 0; i < n; i++ {
+		if v, err := group.Get("remote-hot"); err != nil || v.String() != "v" {
+			t.Fatalf("failed to get remote-hot: %v", err)
+		}
+	}
+	if node.gets >= n {
+		t.Fatal("hot key should be cached locally")
+	}
+	stats := group.CacheStats(HotCache)
+	if stats.Items != 1 || stats.Hits != int64(n-node.gets) {
+		t.Fatalf("unexpected hot cache stats %+v, peer gets %d", stats, node.gets)
+	}
+	if group.CacheStats(MainCache).Items != 0 {
+		t.Fatal("remote values should not be stored in main cache")
+	}
+	// 远程节点的值没有过期时间，热点副本仍然会过期
+	if v, ok := group.hotCache.get("remote-hot"); !ok || v.Expire().IsZero() ||
+		v.Expire().After(time.Now().Add(hotCacheMaxTTL)) {
+		t.Fatalf("hot copy should expire within %v, got %v", hotCacheMaxTTL, v.Expire())
+	}
+
+	if err := group.Remove("remote-hot"); err != nil {
+		t.Fatal(err)
+	}
+	if group.CacheStats(HotCache).Items != 0 {
+		t.Fatal("remove should drop the hot copy")
+	}
+}
+
+type ctxKey struct{}
+
+func TestGroupGetContext(t *testing.T) {
+	group := NewGroup("context", 2<<10, ContextGetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			tenant, _ := ctx.Value(ctxKey{}).(string)
+			return []byte(tenant + "/" + key), nil
+		}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := group.GetContext(ctx, "k"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	ctx = context.WithValue(context.Background(), ctxKey{}, "tenant1")
+	if v, err := group.GetContext(ctx, "k"); err != nil || v.String() != "tenant1/k" {
+		t.Fatalf("expected tenant1/k, got %s %v", v, err)
+	}
+	// 已缓存的值不再访问数据源
+	if v, err := group.Get("k"); err != nil || v.String() != "tenant1/k" {
+		t.Fatalf("expected cached tenant1/k, got %s %v", v, err)
+	}
+}
+
+func TestGroupContextTTLGetter(t *testing.T) {
+	group := NewGroup("context-ttl", 2<<10, ContextTTLGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Duration, error) {
+			tenant, _ := ctx.Value(ctxKey{}).(string)
+			return []byte(tenant + "/" + key), time.Hour, nil
+		}), WithTTL(time.Millisecond))
+
+	ctx := context.WithValue(context.Background(), ctxKey{}, "tenant1")
+	v, err := group.GetContext(ctx, "k")
+	if err != nil || v.String() != "tenant1/k" {
+		t.Fatalf("expected tenant1/k, got %s %v", v, err)
+	}
+	if time.Until(v.Expire()) < time.Minute {
+		t.Fatalf("per-key ttl should be used, expires at %v", v.Expire())
+	}
+}
+
+func TestGroupStats(t *testing.T) {
+	release := make(chan struct{})
+	group := NewGroup("stats", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("db is down")
+		}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = group.Get("A")
+		}()
+	}
+	// 等待所有请求都进入 load 再放行数据源
+	for group.Stats().Loads < 10 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	_, _ = group.Get("A")
+	_, _ = group.Get("unknown")
+
+	stats := group.Stats()
+	expect := Stats{
+		Gets:         12,
+		CacheHits:    1,
+		Loads:        11,
+		LoadsDeduped: 2,
+		LocalLoads:   1,
+		LoadErrors:   1,
+		Bytes:        2,
+		Items:        1,
+	}
+	if stats != expect {
+		t.Fatalf("expected stats %+v, got %+v", expect, stats)
+	}
+}
+
+func TestHTTPPoolMetrics(t *testing.T) {
+	group := NewGroup("metrics", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	_, _ = group.Get("k")
+	_, _ = group.Get("k")
+
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	pool := NewHTTPPool("self")
+	pool.SetNodes("self", srv.URL)
+	err := pool.httpGetters[srv.URL].Get(context.Background(),
+		&pb.Request{Group: "metrics", Key: "k"}, &pb.Response{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	pool.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := ioutil.ReadAll(rec.Body)
+
+	for _, line := range []string{
+		"# TYPE gocache_gets_total counter",
+		`gocache_gets_total{group="metrics"} 3`,
+		`gocache_cache_hits_total{group="metrics"} 2`,
+		`gocache_hit_ratio{group="metrics"} 0.6666666666666666`,
+		`gocache_cache_bytes{group="metrics",cache="main"} 2`,
+		`gocache_inflight_loads{group="metrics"} 0`,
+		"# TYPE gocache_peer_request_duration_seconds histogram",
+		`gocache_peer_request_duration_seconds_bucket{peer="` + srv.URL + `",le="+Inf"} 1`,
+		`gocache_peer_request_duration_seconds_count{peer="` + srv.URL + `"} 1`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Fatalf("metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestGroupEvictionPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy  EvictionPolicy
+		hotKept bool
+	}{{LRU, false}, {LFU, true}, {TinyLFU, true}, {ARC, true}} {
+		loads := make(map[string]int)
+		// 每个缓存占用 2 字节，最多缓存 4 个
+		group := NewGroup("policy", 8, GetterFunc(
+			func(key string) ([]byte, error) {
+				loads[key]++
+				return []byte("v"), nil
+			}), WithEvictionPolicy(tc.policy))
+
+		for i := 0; i < 5; i++ {
+			_, _ = group.Get("h")
+		}
+		// 扫描一批只访问一次的key
+		for _, k := range []string{"a", "b", "c", "d", "e"} {
+			_, _ = group.Get(k)
+		}
+		_, _ = group.Get("h")
+		if kept := loads["h"] == 1; kept != tc.hotKept {
+			t.Fatalf("policy %d: hot key kept %v, expected %v", tc.policy, kept, tc.hotKept)
+		}
+	}
+}
+
+// mapStore 不淘汰、不支持过期时间的 Store
+type mapStore struct {
+	m         map[string]ByteView
+	onEvicted func(string, ByteView)
+}
+
+func (s *mapStore) Add(key string, value ByteView, ttl time.Duration) {
+	s.m[key] = value
+}
+
+func (s *mapStore) Get(key string) (ByteView, bool) {
+	v, ok := s.m[key]
+	return v, ok
+}
+
+func (s *mapStore) Peek(key string) (ByteView, bool) {
+	return s.Get(key)
+}
+
+func (s *mapStore) Remove(key string) bool {
+	v, ok := s.m[key]
+	if ok {
+		delete(s.m, key)
+		s.onEvicted(key, v)
+	}
+	return ok
+}
+
+func (s *mapStore) Len() int {
+	return len(s.m)
+}
+
+func (s *mapStore) Bytes() int64 {
+	return 0
+}
+
+func TestGroupCustomStore(t *testing.T) {
+	stores := 0
+	loads := 0
+	group := NewGroup("store", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			return []byte(key), nil
+		}),
+		WithTTL(10*time.Millisecond),
+		WithStore(func(config *StoreConfig) Store {
+			stores++
+			return &mapStore{m: make(map[string]ByteView), onEvicted: config.OnEvicted}
+		}))
+
+	_, _ = group.Get("k")
+	_, _ = group.Get("k")
+	if stores != 1 || loads != 1 {
+		t.Fatalf("expected custom store to be used, stores %d loads %d", stores, loads)
+	}
+
+	// mapStore 不支持过期时间，由 cache 惰性删除
+	time.Sleep(20 * time.Millisecond)
+	_, _ = group.Get("k")
+	if loads != 2 || group.Stats().Evictions != 1 {
+		t.Fatalf("expired value should be reloaded, loads %d stats %+v", loads, group.Stats())
+	}
+}
+
+// touchStore 实现 Toucher，记录读缓冲补回的访问
+type touchStore struct {
+	mapStore
+	gets, touches int
+}
+
+func (s *touchStore) Get(key string) (ByteView, bool) {
+	s.gets++
+	return s.mapStore.Get(key)
+}
+
+func (s *touchStore) Touch(key string) {
+	s.touches++
+}
+
+func TestGroupStoreToucher(t *testing.T) {
+	var store *touchStore
+	group := NewGroup("toucher", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}),
+		WithStore(func(config *StoreConfig) Store {
+			store = &touchStore{mapStore: mapStore{m: make(map[string]ByteView), onEvicted: config.OnEvicted}}
+			return store
+		}))
+
+	for i := 0; i < 1000; i++ {
+		_, _ = group.Get("k")
+	}
+	// 读缓冲中的访问记录通过 Touch 补回，不再调用 Get
+	if store.touches == 0 || store.gets != 0 {
+		t.Fatalf("expected reads to be replayed with Touch, touches %d gets %d", store.touches, store.gets)
+	}
+}
+
+func TestGroupShards(t *testing.T) {
+	group := NewGroup("shards", 8<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			return []byte(key), nil
+		}), WithShards(8))
+
+	if len(group.mainCache.shards) != 8 || group.mainCache.shards[0].cacheBytes != 1<<10 {
+		t.Fatal("cache bytes should be divided across shards")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_, _ = group.Get(fmt.Sprintf("k%d", j))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := group.CacheStats(MainCache)
+	if stats.Items != 100 || stats.Hits+group.Stats().Loads != 800 {
+		t.Fatalf("unexpected aggregated stats %+v", stats)
+	}
+	used := 0
+	for _, shard := range group.mainCache.shards {
+		if shard.stats().Items > 0 {
+			used++
+		}
+	}
+	if used < 2 {
+		t.Fatal("keys should be spread across shards")
+	}
+}
+
+// 使用 go test -race 运行，检查读路径在并发下是否安全
+func TestGroupConcurrentGet(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, TinyLFU, ARC, Arena} {
+		// 容量较小，读写过程中会不断淘汰
+		group := NewGroup("concurrent", 1<<10, GetterFunc(
+			func(key string) ([]byte, error) {
+				return []byte(key), nil
+			}), WithEvictionPolicy(policy), WithShards(4), WithTTL(time.Millisecond))
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 500; j++ {
+					key := fmt.Sprintf("k%d", (i*j)%200)
+					switch j % 50 {
+					case 0:
+						_ = group.Set(key, []byte(key))
+					case 1:
+						_ = group.Remove(key)
+					default:
+						v, err := group.Get(key)
+						if err != nil || v.String() != key {
+							t.Errorf("policy %d: get %s = %q, %v", policy, key, v.String(), err)
+							return
+						}
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		stats := group.CacheStats(MainCache)
+		if stats.Bytes > 1<<10 || stats.Hits > stats.Gets {
+			t.Fatalf("policy %d: unexpected stats %+v", policy, stats)
+		}
+	}
+}
+
+func TestGroupArenaStore(t *testing.T) {
+	loads := 0
+	group := NewGroup("arena", 1<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, ErrNotFound
+		}), WithEvictionPolicy(Arena), WithTTL(time.Hour), WithNegativeTTL(time.Hour))
+
+	for i := 0; i < 2; i++ {
+		v, err := group.Get("A")
+		if err != nil || v.String() != "1" || v.Expire().IsZero() {
+			t.Fatalf("unexpected value %q %v, expire %v", v.String(), err, v.Expire())
+		}
+		if _, err := group.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 2 {
+		t.Fatalf("values and negative entries should be cached, loaded %d times", loads)
+	}
+}
+
+type user struct {
+	Name string
+	Age  int
+}
+
+func TestTypedGroup(t *testing.T) {
+	loads := 0
+	users := NewTypedGroup[user]("typed-json", 2<<10, JSONCodec[user]{},
+		func(ctx context.Context, key string) (user, error) {
+			loads++
+			if key == "unknown" {
+				return user{}, ErrNotFound
+			}
+			return user{Name: key, Age: len(key)}, nil
+		})
+
+	for i := 0; i < 2; i++ {
+		u, err := users.Get(context.Background(), "tom")
+		if err != nil || u != (user{Name: "tom", Age: 3}) {
+			t.Fatalf("unexpected user %+v %v", u, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("typed values should be cached, loaded %d times", loads)
+	}
+	if _, err := users.Get(context.Background(), "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := users.Set("jerry", user{Name: "jerry", Age: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := users.Get(context.Background(), "jerry"); u.Age != 5 || loads != 2 {
+		t.Fatalf("unexpected user %+v, loaded %d times", u, loads)
+	}
+
+	// 缓存中的值无法解码
+	_ = users.Group().Set("bad", []byte("{"))
+	if _, err := users.Get(context.Background(), "bad"); err == nil {
+		t.Fatal("expected decode error")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	gobCodec := GobCodec[map[string]int]{}
+	data, err := gobCodec.Marshal(map[string]int{"a": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := gobCodec.Unmarshal(data); err != nil || m["a"] != 1 {
+		t.Fatalf("gob: unexpected %v %v", m, err)
+	}
+
+	protoCodec := ProtoCodec[*pb.Request]{}
+	data, err = protoCodec.Marshal(&pb.Request{Group: "g", Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req, err := protoCodec.Unmarshal(data); err != nil || req.GetGroup() != "g" || req.GetKey() != "k" {
+		t.Fatalf("proto: unexpected %v %v", req, err)
+	}
+}
+
+// batchDB 支持批量查询的数据源
+type batchDB struct {
+	mu      sync.Mutex
+	gets    int
+	batches [][]string
+}
+
+func (d *batchDB) Get(key string) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gets++
+	if v, ok := db[key]; ok {
+		return []byte(v), nil
+	}
+	return nil, ErrNotFound
+}
+
+func (d *batchDB) GetMany(keys []string) (map[string][]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.batches = append(d.batches, keys)
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if v, ok := db[key]; ok {
+			values[key] = []byte(v)
+		}
+	}
+	return values, nil
+}
+
+// gatedDB 每次访问数据源前通知 started，等待 release
+type gatedDB struct {
+	batchDB
+	started chan struct{}
+	release chan struct{}
+}
+
+func (d *gatedDB) Get(key string) ([]byte, error) {
+	d.started <- struct{}{}
+	<-d.release
+	return d.batchDB.Get(key)
+}
+
+func (d *gatedDB) GetMany(keys []string) (map[string][]byte, error) {
+	d.started <- struct{}{}
+	<-d.release
+	return d.batchDB.GetMany(keys)
+}
+
+func TestGroupGetMultiSharesLoads(t *testing.T) {
+	source := &gatedDB{started: make(chan struct{}, 2), release: make(chan struct{})}
+	group := NewGroup("multi-shared", 2<<10, source)
+
+	// GetMulti 批量加载期间，Get 等待批量加载的结果
+	multi := make(chan map[string]ByteView)
+	go func() {
+		views, _ := group.GetMulti(context.Background(), []string{"A", "B"})
+		multi <- views
+	}()
+	<-source.started
+	get := make(chan ByteView)
+	go func() {
+		v, _ := group.Get("A")
+		get <- v
+	}()
+	for group.Stats().Loads != 3 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	source.release <- struct{}{}
+	if views := <-multi; views["A"].String() != "1" || views["B"].String() != "2" {
+		t.Fatalf("unexpected views %v", views)
+	}
+	if v := <-get; v.String() != "1" {
+		t.Fatalf("expected 1, got %s", v)
+	}
+
+	// Get 加载期间，GetMulti 只批量加载其他key
+	go func() {
+		v, _ := group.Get("C")
+		get <- v
+	}()
+	<-source.started
+	go func() {
+		views, _ := group.GetMulti(context.Background(), []string{"C", "D"})
+		multi <- views
+	}()
+	<-source.started
+	source.release <- struct{}{}
+	source.release <- struct{}{}
+	if v := <-get; v.String() != "3" {
+		t.Fatalf("expected 3, got %s", v)
+	}
+	if views := <-multi; len(views) != 1 || views["C"].String() != "3" {
+		t.Fatalf("unexpected views %v", views)
+	}
+
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	if source.gets != 1 || !reflect.DeepEqual(source.batches, [][]string{{"A", "B"}, {"D"}}) {
+		t.Fatalf("expected 1 get and batches [A B] [D], got %d %v", source.gets, source.batches)
+	}
+}
+
+// batchNode 支持批量请求的远程节点
+type batchNode struct {
+	fakeNode
+	batches int
+}
+
+func (n *batchNode) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	n.batches++
+	for _, key := range in.GetKeys() {
+		if v, ok := n.sets[key]; ok {
+			out.Entries = append(out.Entries, &pb.Entry{Key: key, Value: v})
+		} else {
+			out.Entries = append(out.Entries, &pb.Entry{Key: key, NotFound: true})
+		}
+	}
+	return nil
+}
+
+type batchPicker struct {
+	node NodeGetter
+}
+
+func (p *batchPicker) PickNode(key string) (NodeGetter, bool) {
+	if strings.HasPrefix(key, "remote-") {
+		return p.node, true
+	}
+	return nil, false
+}
+
+// slowNode 远程节点直到ctx结束才返回
+type slowNode struct {
+	batchNode
+}
+
+func (n *slowNode) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (n *slowNode) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestGroupPeerTimeout(t *testing.T) {
+	var loads int32
+	group := NewGroup("peer-timeout", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return []byte(key), nil
+		}))
+	group.RegisterPicker(&batchPicker{node: &slowNode{}})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := group.GetContext(ctx, "remote-k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	_, err := group.GetMulti(ctx, []string{"remote-a", "remote-b"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded from GetMulti, got %v", err)
+	}
+
+	// 调用方放弃之后不能改为访问本地数据源
+	if n := atomic.LoadInt32(&loads); n != 0 {
+		t.Fatalf("data source should not be called after the caller gave up, called %d times", n)
+	}
+	if s := group.Stats(); s.PeerErrors != 0 || s.Items != 0 {
+		t.Fatalf("unexpected stats %+v", s)
+	}
+}
+
+// failNode 批量请求总是失败的远程节点
+type failNode struct {
+	batchNode
+}
+
+func (n *failNode) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return errors.New("node down")
+}
+
+// panicBatchDB GetMany 和 Get 都会 panic 的数据源
+type panicBatchDB struct{}
+
+func (panicBatchDB) Get(key string) ([]byte, error) {
+	panic("get boom")
+}
+
+func (panicBatchDB) GetMany(keys []string) (map[string][]byte, error) {
+	panic("get many boom")
+}
+
+func TestGroupGetMultiPanic(t *testing.T) {
+	getters := map[string]DataGetter{
+		"batch": panicBatchDB{},
+		"single": GetterFunc(func(key string) ([]byte, error) {
+			panic("get boom")
+		}),
+	}
+	for name, getter := range getters {
+		group := NewGroup("multi-panic-"+name, 2<<10, getter)
+		group.RegisterPicker(&batchPicker{node: &failNode{}})
+
+		// 远程节点失败后从本地数据源加载，以及本节点负责的key，panic 都转换为错误
+		keys := []string{"remote-a", "remote-b"}
+		if name == "batch" {
+			keys = append(keys, "local")
+		}
+		views, errs := group.getMulti(context.Background(), keys)
+		if len(views) != 0 || len(errs) != len(keys) {
+			t.Fatalf("%s: unexpected result %v %v", name, views, errs)
+		}
+		for key, err := range errs {
+			var pe *singlereq.PanicError
+			if !errors.As(err, &pe) {
+				t.Fatalf("%s: expected PanicError for %s, got %v", name, key, err)
+			}
+		}
+		if n := group.Stats().LoadErrors; n != int64(len(keys)) {
+			t.Fatalf("%s: expected %d load errors, got %d", name, len(keys), n)
+		}
+	}
+}
+
+func TestGroupGetMulti(t *testing.T) {
+	source := &batchDB{}
+	group := NewGroup("multi", 2<<10, source, WithNegativeTTL(time.Hour))
+	node := &batchNode{fakeNode: fakeNode{sets: map[string][]byte{"remote-1": []byte("r1"), "remote-2": []byte("r2")}}}
+	group.RegisterPicker(&batchPicker{node: node})
+
+	_, _ = group.Get("A")
+	keys := []string{"A", "B", "C", "unknown", "remote-1", "remote-2", "remote-3", "B"}
+	views, err := group.GetMulti(context.Background(), keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"A": "1", "B": "2", "C": "3", "remote-1": "r1", "remote-2": "r2"}
+	if len(views) != len(expect) {
+		t.Fatalf("unexpected views %v", views)
+	}
+	for k, v := range expect {
+		if views[k].String() != v {
+			t.Fatalf("%s: expected %s, got %s", k, v, views[k].String())
+		}
+	}
+	if node.batches != 1 || node.gets != 0 {
+		t.Fatalf("remote keys should be fetched in one batch, batches %d gets %d", node.batches, node.gets)
+	}
+	if len(source.batches) != 1 || len(source.batches[0]) != 3 || source.gets != 1 {
+		t.Fatalf("local misses should be loaded in one batch, batches %v gets %d", source.batches, source.gets)
+	}
+
+	// 第二次全部命中本机缓存(包括空值缓存)
+	views, _ = group.GetMulti(context.Background(), []string{"B", "C", "unknown"})
+	if len(views) != 2 || len(source.batches) != 1 {
+		t.Fatalf("second call should hit cache, views %v batches %v", views, source.batches)
+	}
+	if stats := group.Stats(); stats.Gets != 11 || stats.CacheHits != 4 || stats.Loads != 7 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestHTTPPoolGetMulti(t *testing.T) {
+	NewGroup("multi-http", 2<<10, &batchDB{})
+	srv := httptest.NewServer(NewHTTPPool("self"))
+	defer srv.Close()
+	getter := &httpGetter{baseURL: srv.URL + defaultBasePath}
+
+	out := &pb.BatchResponse{}
+	err := getter.GetMulti(context.Background(), &pb.BatchRequest{Group: "multi-http", Keys: []string{"A", "unknown"}}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]*pb.Entry)
+	for _, e := range out.GetEntries() {
+		entries[e.GetKey()] = e
+	}
+	if len(entries) != 2 || string(entries["A"].GetValue()) != "1" || !entries["unknown"].GetNotFound() {
+		t.Fatalf("unexpected entries %v", out.GetEntries())
+	}
+}
+
+func TestGroupBatchWindow(t *testing.T) {
+	source := &batchDB{}
+	group := NewGroup("batch-window", 2<<10, source,
+		WithBatchWindow(20*time.Millisecond, 4), WithNegativeTTL(time.Hour))
+
+	keys := []string{"A", "B", "C", "unknown", "x1", "x2"}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		for _, key := range keys {
+			wg.Add(1)
+			go func(key string) {
+				defer wg.Done()
+				v, err := group.Get(key)
+				if want, ok := db[key]; ok && (err != nil || v.String() != want) {
+					t.Errorf("%s: expected %s, got %s %v", key, want, v.String(), err)
+				}
+				if _, ok := db[key]; !ok && !errors.Is(err, ErrNotFound) {
+					t.Errorf("%s: expected ErrNotFound, got %v", key, err)
+				}
+			}(key)
+		}
+	}
+	wg.Wait()
+
+	// 6 个不同的key，每批最多 4 个；同一个key经过 singlereq 去重，不会重复加载
+	loaded := 0
+	for _, batch := range source.batches {
+		if len(batch) > 4 {
+			t.Fatalf("batch %v exceeds max batch", batch)
+		}
+		loaded += len(batch)
+	}
+	if source.gets != 0 || len(source.batches) < 2 || loaded != len(keys) {
+		t.Fatalf("unexpected batches %v, gets %d", source.batches, source.gets)
+	}
+	if len(source.batches) > 3 {
+		t.Fatalf("misses should be coalesced, got %d batches", len(source.batches))
+	}
+}
+
+func TestGroupLoadWaiterCancel(t *testing.T) {
+	release := make(chan struct{})
+	group := NewGroup("waiter-cancel", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			<-release
+			return []byte(key), nil
+		}))
+
+	done := make(chan error)
+	go func() {
+		_, err := group.Get("k")
+		done <- err
+	}()
+	for group.singleReq.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 等待中的调用方超时放弃，不影响进行中的加载
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := group.GetContext(ctx, "k"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if stats := group.Stats(); stats.LoadsDeduped != 1 {
+		t.Fatalf("expected one load, got %+v", stats)
+	}
+}
+
+func TestGroupGetterPanic(t *testing.T) {
+	panics := true
+	group := NewGroup("panic", 2<<10, GetterFunc(
+		func(key string) ([]byte, error) {
+			if panics {
+				panic("db driver bug")
+			}
+			return []byte(key), nil
+		}))
+
+	var pe *singlereq.PanicError
+	if _, err := group.Get("k"); !errors.As(err, &pe) || pe.Value != "db driver bug" {
+		t.Fatalf("expected PanicError, got %v", err)
+	}
+	if stats := group.Stats(); stats.LoadErrors != 1 {
+		t.Fatalf("panic should be counted as load error, got %+v", stats)
+	}
+
+	// panic 之后同一个key可以继续加载
+	panics = false
+	if v, err := group.Get("k"); err != nil || v.String() != "k" {
+		t.Fatalf("expected k, got %s %v", v, err)
+	}
+}
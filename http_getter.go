@@ -1,11 +1,14 @@
 package gocache
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
+	"time"
 
 	pb "github.com/devhg/gocache/gocachepb"
 	"google.golang.org/protobuf/proto"
@@ -15,11 +18,18 @@ import (
 type NodeGetter interface {
 	// 用于从对应 group 查找对应key的缓存值
 	HTTPGet(group, key string) ([]byte, error)
-	Get(*pb.Request, *pb.Response) error
+	Get(context.Context, *pb.Request, *pb.Response) error
+	// 在对应 group 中设置key的缓存值
+	Set(*pb.SetRequest) error
+	// 删除对应 group 中key的缓存
+	Remove(*pb.Request) error
 }
 
 type httpGetter struct {
 	baseURL string // http://10.0.0.1:9305/_cache/
+
+	// Get 请求耗时，用于 HTTPPool.MetricsHandler
+	latency *histogram
 }
 
 // 普通http通信
@@ -45,18 +55,25 @@ func (h *httpGetter) HTTPGet(group, key string) ([]byte, error) {
 }
 
 // protobuf通信
-func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	URL := fmt.Sprintf(
-		"%v%v/%v",
-		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
-	)
-	res, err := http.Get(URL)
+func (h *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	start := time.Now()
+	defer func() {
+		h.latency.observe(time.Since(start).Seconds())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.keyURL(in.GetGroup(), in.GetKey()), nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
+	// 没有 notFoundHeader 的 404 可能来自旧版本节点或者代理，按普通错误处理
+	if res.StatusCode == http.StatusNotFound && res.Header.Get(notFoundHeader) != "" {
+		return ErrNotFound
+	}
 	if res.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned: %v", res.Status)
 	}
@@ -73,4 +90,80 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	return nil
 }
 
+// GetMulti 使用POST请求批量获取远程节点的缓存
+func (h *httpGetter) GetMulti(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	start := time.Now()
+	defer func() {
+		h.latency.observe(time.Since(start).Seconds())
+	}()
+
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	URL := fmt.Sprintf("%v%v/", h.baseURL, url.QueryEscape(in.GetGroup()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %v", err)
+	}
+	if err = proto.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decoding response body: %v", err)
+	}
+	return nil
+}
+
+// Set 使用PUT请求设置远程节点的缓存
+func (h *httpGetter) Set(in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(http.MethodPut, h.keyURL(in.GetGroup(), in.GetKey()), body)
+}
+
+// Remove 使用DELETE请求删除远程节点的缓存
+func (h *httpGetter) Remove(in *pb.Request) error {
+	return h.do(http.MethodDelete, h.keyURL(in.GetGroup(), in.GetKey()), nil)
+}
+
+func (h *httpGetter) keyURL(group, key string) string {
+	return fmt.Sprintf(
+		"%v%v/%v",
+		h.baseURL,
+		url.QueryEscape(group),
+		url.QueryEscape(key),
+	)
+}
+
+// do 发送没有响应体的请求
+func (h *httpGetter) do(method, URL string, body []byte) error {
+	req, err := http.NewRequest(method, URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned: %v", res.Status)
+	}
+	return nil
+}
+
 var _ NodeGetter = (*httpGetter)(nil)
+var _ BatchNodeGetter = (*httpGetter)(nil)
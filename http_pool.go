@@ -1,7 +1,9 @@
 package gocache
 
 import (
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"strings"
@@ -15,6 +17,9 @@ import (
 const (
 	defaultBasePath   = "/_cache/"
 	defaultVirtualNum = 50
+
+	// notFoundHeader 标记key不存在的响应。只看 404 无法区分旧版本节点或者代理返回的 404
+	notFoundHeader = "X-Gocache-Not-Found"
 )
 
 // NodePicker 节点选择器
@@ -70,20 +75,43 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	group := GetGroup(groupName)
 
 	if group == nil {
-		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
+		http.Error(w, "no such group: "+groupName, http.StatusBadRequest)
 		return
 	}
 
-	byteView, err := group.Get(key)
+	switch r.Method {
+	case http.MethodPut:
+		p.serveSet(w, r, group, key)
+		return
+	case http.MethodDelete:
+		group.removeLocally(key)
+		return
+	case http.MethodPost:
+		// POST /<basepath>/<groupname>/ 批量获取
+		p.serveGetMulti(w, r, group)
+		return
+	}
 
-	if err != nil {
+	// 请求方取消请求时，同时取消本节点的加载
+	byteView, err := group.GetContext(r.Context(), key)
+
+	// key 不存在时带上 notFoundHeader，请求方据此返回 ErrNotFound
+	if errors.Is(err, ErrNotFound) {
+		w.Header().Set(notFoundHeader, "1")
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	resp, err := proto.Marshal(&pb.Response{Value: byteView.ByteSlice()})
+	resp, err := proto.Marshal(&pb.Response{
+		Value:  byteView.ByteSlice(),
+		Expire: expireToUnixNano(byteView.e),
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -91,6 +119,63 @@ func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(resp)
 }
 
+// serveSet 处理其他节点转发的 Group.Set 请求
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.SetRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	group.setLocally(key, ByteView{b: in.GetValue(), e: expireFromUnixNano(in.GetExpire())})
+}
+
+// serveGetMulti 处理其他节点的 GetMulti 批量请求
+func (p *HTTPPool) serveGetMulti(w http.ResponseWriter, r *http.Request, group *Group) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	in := &pb.BatchRequest{}
+	if err = proto.Unmarshal(body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	views, errs := group.getMulti(r.Context(), in.GetKeys())
+	out := &pb.BatchResponse{Entries: make([]*pb.Entry, 0, len(views)+len(errs))}
+	for key, byteView := range views {
+		out.Entries = append(out.Entries, &pb.Entry{
+			Key:    key,
+			Value:  byteView.ByteSlice(),
+			Expire: expireToUnixNano(byteView.e),
+		})
+	}
+	for key, err := range errs {
+		entry := &pb.Entry{Key: key}
+		if errors.Is(err, ErrNotFound) {
+			entry.NotFound = true
+		} else {
+			entry.Error = err.Error()
+		}
+		out.Entries = append(out.Entries, entry)
+	}
+
+	resp, err := proto.Marshal(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	_, _ = w.Write(resp)
+}
+
 // Set the pool's list of nodes' key.
 // example: key=http://10.0.0.1:9305
 func (p *HTTPPool) SetNodes(nodeKeys ...string) {
@@ -103,7 +188,10 @@ func (p *HTTPPool) SetNodes(nodeKeys ...string) {
 
 	p.httpGetters = make(map[string]*httpGetter)
 	for _, nodeKey := range nodeKeys {
-		p.httpGetters[nodeKey] = &httpGetter{baseURL: nodeKey + p.basePath}
+		p.httpGetters[nodeKey] = &httpGetter{
+			baseURL: nodeKey + p.basePath,
+			latency: newHistogram(defaultLatencyBuckets),
+		}
 	}
 }
 
@@ -120,4 +208,19 @@ func (p *HTTPPool) PickNode(key string) (NodeGetter, bool) {
 	return nil, false
 }
 
+// ListNodes 返回除本节点以外的所有节点
+func (p *HTTPPool) ListNodes() map[string]NodeGetter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	nodes := make(map[string]NodeGetter, len(p.httpGetters))
+	for nodeKey, getter := range p.httpGetters {
+		if nodeKey != p.selfAddr {
+			nodes[nodeKey] = getter
+		}
+	}
+	return nodes
+}
+
 var _ NodePicker = (*HTTPPool)(nil)
+var _ NodeLister = (*HTTPPool)(nil)
@@ -0,0 +1,98 @@
+package gocache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	pb "github.com/devhg/gocache/gocachepb"
+)
+
+const (
+	invalidateAttempts = 3                     // 每个节点最多尝试的次数
+	invalidateBackoff  = 50 * time.Millisecond // 重试间隔，随重试次数线性增长
+)
+
+// NodeLister 可选接口，NodePicker 同时实现该接口时，Group.InvalidateAll 可以通知到所有节点
+type NodeLister interface {
+	// 返回除本节点以外的所有节点，key为节点地址
+	ListNodes() map[string]NodeGetter
+}
+
+// InvalidateResult 某个节点的删除结果
+type InvalidateResult struct {
+	Node     string // 节点地址，NodePicker 没有实现 NodeLister 时为空，表示负责key的节点
+	Attempts int    // 尝试次数
+	Err      error  // 重试后仍然失败的错误
+}
+
+// InvalidateAll 删除集群中所有节点上key的缓存，而不只是一致性哈希选出的节点。
+// 非负责节点在远程节点失败时也会缓存key，数据更新后需要通知所有节点。
+// 并发通知每个节点，失败时重试，返回每个节点的结果；有节点失败时返回错误。
+// NodePicker 没有实现 NodeLister 时，只能通知 PickNode 选出的负责节点
+func (g *Group) InvalidateAll(key string) ([]InvalidateResult, error) {
+	if key == "" {
+		return nil, fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+
+	if g.picker == nil {
+		return nil, nil
+	}
+	var nodes map[string]NodeGetter
+	if lister, ok := g.picker.(NodeLister); ok {
+		nodes = lister.ListNodes()
+	} else if owner, ok := g.picker.PickNode(key); ok {
+		nodes = map[string]NodeGetter{"": owner}
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results = make([]InvalidateResult, 0, len(nodes))
+	)
+	request := &pb.Request{Group: g.name, Key: key}
+	for addr, node := range nodes {
+		wg.Add(1)
+		go func(addr string, node NodeGetter) {
+			defer wg.Done()
+			res := invalidateNode(node, request)
+			res.Node = addr
+
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}(addr, node)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Node < results[j].Node
+	})
+
+	failed := 0
+	for _, res := range results {
+		if res.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("invalidate %s failed on %d of %d node(s)", key, failed, len(results))
+	}
+	return results, nil
+}
+
+// invalidateNode 删除某个节点上的缓存，失败时重试
+func invalidateNode(node NodeGetter, request *pb.Request) (res InvalidateResult) {
+	for res.Attempts < invalidateAttempts {
+		if res.Attempts > 0 {
+			time.Sleep(time.Duration(res.Attempts) * invalidateBackoff)
+		}
+		res.Attempts++
+		if res.Err = node.Remove(request); res.Err == nil {
+			return
+		}
+	}
+	return
+}
@@ -0,0 +1,307 @@
+package lfu
+
+import (
+	"container/list"
+	"time"
+)
+
+/**
+LFU(Least Frequently Used) 最不经常使用 ---淘汰访问次数最少的缓存
+
+O(1) 实现：
+* freqs 是按访问次数递增排列的链表，每个节点是一个访问次数相同的缓存组成的链表(频率桶)
+* 访问缓存时，将其从当前频率桶移动到下一个频率桶(不存在则创建)
+* 淘汰时，从访问次数最少的频率桶中淘汰最久没有被访问的缓存
+
+老化：
+单纯的LFU中，过去很热但现在不再访问的缓存访问次数很高，会一直占据缓存。
+每访问 agingInterval 次，所有缓存的访问次数减半，使访问次数反映近期的访问情况。
+*/
+
+const (
+	maxBytes   = 1 << 32
+	maxEntries = 10 << 10
+)
+
+type Cache struct {
+	maxBytes int64 // 最大使用内存
+	nowBytes int64 // 已经使用的内存
+
+	maxEntries int
+
+	// 默认过期时间，0表示永不过期
+	ttl time.Duration
+
+	freqs *list.List // 频率桶，按访问次数递增排列，元素为 *bucket
+	cache map[string]*entry
+
+	// 每访问 agingInterval 次，所有缓存的访问次数减半
+	agingInterval int
+	accesses      int
+
+	// 是某条记录被移除时的回调函数，可以为 nil
+	onEvicted func(key string, value Value)
+}
+
+// a config for cache
+type CacheConfig struct {
+	MaxBytes   int64 // 最大使用内存
+	MaxEntries int   // 最大缓存数目
+
+	// 默认过期时间，Add 添加的缓存使用该值，0表示永不过期
+	TTL time.Duration
+
+	// 老化间隔，每访问 AgingInterval 次所有缓存的访问次数减半
+	// 默认为最大缓存数目的10倍，小于0表示不老化
+	AgingInterval int
+
+	// 淘汰回调函数
+	OnEvicted func(string, Value)
+}
+
+// Value use Len to count how many bytes it takes
+type Value interface {
+	Len() int
+}
+
+// bucket 频率桶，保存访问次数相同的缓存，队首为最近访问的缓存
+type bucket struct {
+	freq    int
+	entries *list.List // 元素为 *entry
+}
+
+type entry struct {
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
+
+	bucket *list.Element // 所在的频率桶
+	ele    *list.Element // 在频率桶中的位置
+}
+
+// expired 判断缓存是否在now时刻已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func New(config *CacheConfig) *Cache {
+	c := &Cache{
+		maxBytes:   maxBytes,
+		maxEntries: maxEntries,
+		freqs:      list.New(),
+		cache:      make(map[string]*entry),
+	}
+	if config != nil {
+		if config.MaxBytes != 0 {
+			c.maxBytes = config.MaxBytes
+		}
+		if config.MaxEntries != 0 {
+			c.maxEntries = config.MaxEntries
+		}
+		if config.TTL > 0 {
+			c.ttl = config.TTL
+		}
+		c.agingInterval = config.AgingInterval
+		c.onEvicted = config.OnEvicted
+	}
+	if c.agingInterval == 0 {
+		c.agingInterval = 10 * c.maxEntries
+	}
+	return c
+}
+
+// 按key 添加缓存，使用默认过期时间
+func (c *Cache) Add(key string, val Value) {
+	c.AddWithTTL(key, val, c.ttl)
+}
+
+// AddWithTTL 按key 添加缓存，并指定过期时间，ttl<=0表示永不过期
+func (c *Cache) AddWithTTL(key string, val Value, ttl time.Duration) {
+	if c.cache == nil {
+		c.cache = make(map[string]*entry)
+		c.freqs = list.New()
+	}
+
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
+	if e, ok := c.cache[key]; ok {
+		// 缓存命中，更新缓存内容并增加访问次数
+		c.nowBytes += int64(val.Len()) - int64(e.value.Len())
+		e.value = val
+		e.expire = expire
+		c.increment(e)
+	} else {
+		// 缓存未命中，新缓存的访问次数为1
+		e := &entry{key: key, value: val, expire: expire}
+		front := c.freqs.Front()
+		if front == nil || front.Value.(*bucket).freq != 1 {
+			front = c.freqs.PushFront(&bucket{freq: 1, entries: list.New()})
+		}
+		e.bucket = front
+		e.ele = front.Value.(*bucket).entries.PushFront(e)
+		c.cache[key] = e
+		c.nowBytes += int64(val.Len() + len(key))
+	}
+
+	// 超过最大缓存数目 淘汰
+	if c.maxEntries != 0 && len(c.cache) > c.maxEntries {
+		c.RemoveLeastFrequent()
+	}
+
+	// 超过最大缓存容量 淘汰
+	for c.maxBytes != 0 && c.nowBytes > c.maxBytes && len(c.cache) > 0 {
+		c.RemoveLeastFrequent()
+	}
+	c.access()
+}
+
+// 获取缓存
+func (c *Cache) Get(key string) (val Value, ok bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	e, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	// 惰性删除：访问时发现已过期则直接淘汰
+	if e.expired(time.Now()) {
+		c.removeEntry(e)
+		return nil, false
+	}
+	c.increment(e)
+	c.access()
+	return e.value, true
+}
+
+// Peek 获取缓存但不增加访问次数，也不删除过期缓存。
+// 不修改任何内部状态，多个 Peek 可以在读锁下并发调用
+func (c *Cache) Peek(key string) (val Value, ok bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	e, ok := c.cache[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// increment 将缓存移动到下一个频率桶
+func (c *Cache) increment(e *entry) {
+	cur := e.bucket
+	freq := cur.Value.(*bucket).freq
+
+	next := cur.Next()
+	if next == nil || next.Value.(*bucket).freq != freq+1 {
+		next = c.freqs.InsertAfter(&bucket{freq: freq + 1, entries: list.New()}, cur)
+	}
+	c.unlink(e)
+	e.bucket = next
+	e.ele = next.Value.(*bucket).entries.PushFront(e)
+}
+
+// unlink 将缓存从所在的频率桶中移除，频率桶为空时删除频率桶
+func (c *Cache) unlink(e *entry) {
+	b := e.bucket.Value.(*bucket)
+	b.entries.Remove(e.ele)
+	if b.entries.Len() == 0 {
+		c.freqs.Remove(e.bucket)
+	}
+}
+
+// access 记录一次访问，达到老化间隔时老化
+func (c *Cache) access() {
+	if c.agingInterval <= 0 {
+		return
+	}
+	c.accesses++
+	if c.accesses >= c.agingInterval {
+		c.accesses = 0
+		c.age()
+	}
+}
+
+// age 所有缓存的访问次数减半(至少为1)，减半后访问次数相同的频率桶合并
+func (c *Cache) age() {
+	for ele := c.freqs.Front(); ele != nil; {
+		next := ele.Next()
+		b := ele.Value.(*bucket)
+		b.freq /= 2
+		if b.freq < 1 {
+			b.freq = 1
+		}
+
+		// 与前一个频率桶合并，前一个频率桶的缓存访问次数更少，放在队尾
+		if prev := ele.Prev(); prev != nil && prev.Value.(*bucket).freq == b.freq {
+			pb := prev.Value.(*bucket)
+			for e := b.entries.Back(); e != nil; e = b.entries.Back() {
+				en := b.entries.Remove(e).(*entry)
+				en.bucket = prev
+				en.ele = pb.entries.PushFront(en)
+			}
+			c.freqs.Remove(ele)
+		}
+		ele = next
+	}
+}
+
+// RemoveLeastFrequent 删除访问次数最少的缓存，访问次数相同时删除最久没有访问的
+func (c *Cache) RemoveLeastFrequent() {
+	if c.cache == nil {
+		return
+	}
+	if front := c.freqs.Front(); front != nil {
+		c.removeEntry(front.Value.(*bucket).entries.Back().Value.(*entry))
+	}
+}
+
+// 按key 删除缓存
+func (c *Cache) Remove(key string) bool {
+	if c.cache == nil {
+		return false
+	}
+	if e, ok := c.cache[key]; ok {
+		c.removeEntry(e)
+		return true
+	}
+	return false
+}
+
+func (c *Cache) removeEntry(e *entry) {
+	c.unlink(e)
+
+	// 缓存容量减少
+	c.nowBytes -= int64(len(e.key)) + int64(e.value.Len())
+	delete(c.cache, e.key)
+
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+// RemoveExpired 删除所有已过期的缓存，返回删除的数目
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, e := range c.cache {
+		if e.expired(now) {
+			c.removeEntry(e)
+			n++
+		}
+	}
+	return n
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
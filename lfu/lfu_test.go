@@ -0,0 +1,99 @@
+package lfu
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	lfu := New(&CacheConfig{MaxEntries: 2})
+	lfu.Add("key", String("value"))
+	lfu.Add("key2", String("value2"))
+
+	if val, ok := lfu.Get("key"); !ok || string(val.(String)) != "value" {
+		t.Fatal("get value error")
+	}
+	if _, ok := lfu.Get("key3"); ok {
+		t.Fatal("key3 should not exist")
+	}
+
+	lfu.Add("key", String("v"))
+	if val, _ := lfu.Get("key"); string(val.(String)) != "v" {
+		t.Fatal("value should be updated")
+	}
+	if lfu.Bytes() != int64(len("key"+"v"+"key2"+"value2")) {
+		t.Fatalf("unexpected bytes %d", lfu.Bytes())
+	}
+}
+
+func TestRemoveLeastFrequent(t *testing.T) {
+	keys := make([]string, 0)
+	lfu := New(&CacheConfig{
+		MaxEntries: 3,
+		OnEvicted: func(key string, value Value) {
+			keys = append(keys, key)
+		},
+	})
+	lfu.Add("k1", String("v1"))
+	lfu.Add("k2", String("v2"))
+	lfu.Add("k3", String("v3"))
+
+	// k1 访问两次，k3 访问一次，添加 k4 时淘汰访问次数最少的 k2
+	lfu.Get("k1")
+	lfu.Get("k1")
+	lfu.Get("k3")
+	lfu.Add("k4", String("v4"))
+
+	// k4 与 k5 访问次数相同，淘汰更久没有访问的 k4
+	lfu.Add("k5", String("v5"))
+	if !reflect.DeepEqual(keys, []string{"k2", "k4"}) {
+		t.Fatalf("unexpected evicted keys %v", keys)
+	}
+	if lfu.Len() != 3 {
+		t.Fatalf("unexpected len %d", lfu.Len())
+	}
+}
+
+func TestAging(t *testing.T) {
+	lfu := New(&CacheConfig{AgingInterval: 20})
+	lfu.Add("old", String("v"))
+	for i := 0; i < 15; i++ {
+		lfu.Get("old")
+	}
+	lfu.Add("new", String("v"))
+
+	// 不老化时 old 访问16次，new 访问13次；
+	// 第20次访问时老化，old 8次 new 2次，之后 new 再访问9次超过 old
+	for i := 0; i < 12; i++ {
+		lfu.Get("new")
+	}
+	lfu.RemoveLeastFrequent()
+	if _, ok := lfu.Get("old"); ok {
+		t.Fatal("old should be evicted after aging")
+	}
+	if _, ok := lfu.Get("new"); !ok {
+		t.Fatal("new should stay")
+	}
+}
+
+func TestAddWithTTL(t *testing.T) {
+	lfu := New(&CacheConfig{TTL: 10 * time.Millisecond})
+	lfu.Add("k1", String("v1"))
+	lfu.AddWithTTL("k2", String("v2"), time.Hour)
+	lfu.AddWithTTL("k3", String("v3"), time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := lfu.Get("k1"); ok {
+		t.Fatal("k1 should be expired")
+	}
+	if n := lfu.RemoveExpired(); n != 1 || lfu.Len() != 1 {
+		t.Fatalf("expected 1 expired entry removed, got %d, len %d", n, lfu.Len())
+	}
+}
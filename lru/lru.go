@@ -2,6 +2,7 @@ package lru
 
 import (
 	"container/list"
+	"time"
 )
 
 const (
@@ -15,6 +16,9 @@ type Cache struct {
 
 	maxEntries int
 
+	// 默认过期时间，0表示永不过期
+	ttl time.Duration
+
 	ll    *list.List
 	cache map[string]*list.Element
 
@@ -27,6 +31,9 @@ type CacheConfig struct {
 	MaxBytes   int64 // 最大使用内存
 	MaxEntries int   // 最大缓存数目
 
+	// 默认过期时间，Add 添加的缓存使用该值，0表示永不过期
+	TTL time.Duration
+
 	// 淘汰回调函数
 	OnEvicted func(string, Value)
 }
@@ -38,8 +45,14 @@ type Value interface {
 
 // 双向链表节点的数据类型，保存key的目的是淘汰队首节点时，
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
+}
+
+// expired 判断缓存是否在now时刻已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
 }
 
 func New(config *CacheConfig) *Cache {
@@ -62,30 +75,44 @@ func New(config *CacheConfig) *Cache {
 	if config.OnEvicted != nil {
 		c.onEvicted = config.OnEvicted
 	}
+	if config.TTL > 0 {
+		c.ttl = config.TTL
+	}
 	return c
 }
 
-// 按key 添加缓存
+// 按key 添加缓存，使用默认过期时间
 func (c *Cache) Add(key string, val Value) {
+	c.AddWithTTL(key, val, c.ttl)
+}
+
+// AddWithTTL 按key 添加缓存，并指定过期时间，ttl<=0表示永不过期
+func (c *Cache) AddWithTTL(key string, val Value, ttl time.Duration) {
 	if c.cache == nil {
 		c.cache = make(map[string]*list.Element)
 		c.ll = list.New()
 	}
 
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
 	// 缓存命中
 	if ele, ok := c.cache[key]; ok {
 		c.ll.MoveToFront(ele)
 		// 更新缓存内容
-		kv := ele.Value.(*entry).value
-		c.nowBytes += int64(val.Len()) - int64(kv.Len())
-		return
+		kv := ele.Value.(*entry)
+		c.nowBytes += int64(val.Len()) - int64(kv.value.Len())
+		kv.value = val
+		kv.expire = expire
+	} else {
+		// 缓存未命中
+		ele := c.ll.PushFront(&entry{key: key, value: val, expire: expire})
+		c.nowBytes += int64(val.Len() + len(key))
+		c.cache[key] = ele
 	}
 
-	// 缓存未命中
-	ele := c.ll.PushFront(&entry{key: key, value: val})
-	c.nowBytes += int64(val.Len() + len(key))
-	c.cache[key] = ele
-
 	// 超过最大缓存数目 淘汰
 	if c.maxEntries != 0 && c.ll.Len() > c.maxEntries {
 		c.RemoveOldest()
@@ -139,13 +166,57 @@ func (c *Cache) Get(key string) (val Value, ok bool) {
 		return nil, false
 	}
 	if ele, ok := c.cache[key]; ok {
-		c.ll.PushFront(ele)
 		val := ele.Value.(*entry)
+		// 惰性删除：访问时发现已过期则直接淘汰
+		if val.expired(time.Now()) {
+			c.removeElement(ele)
+			return nil, false
+		}
+		c.ll.MoveToFront(ele)
 		return val.value, true
 	}
 	return
 }
 
+// Peek 获取缓存但不调整淘汰顺序，也不删除过期缓存。
+// 不修改任何内部状态，多个 Peek 可以在读锁下并发调用
+func (c *Cache) Peek(key string) (val Value, ok bool) {
+	if c.cache == nil {
+		return nil, false
+	}
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		if e.expired(time.Now()) {
+			return nil, false
+		}
+		return e.value, true
+	}
+	return
+}
+
+// RemoveExpired 删除所有已过期的缓存，返回删除的数目
+func (c *Cache) RemoveExpired() int {
+	if c.cache == nil {
+		return 0
+	}
+	now := time.Now()
+	n := 0
+	for ele := c.ll.Back(); ele != nil; {
+		prev := ele.Prev()
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele)
+			n++
+		}
+		ele = prev
+	}
+	return n
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
 // Len the number of cache entries
 func (c *Cache) Len() int {
 	return c.ll.Len()
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"testing"
+	"time"
 )
 
 type String string
@@ -70,3 +71,82 @@ func TestOnEvicted(t *testing.T) {
 
 	fmt.Println(keys)
 }
+
+func TestAddWithTTL(t *testing.T) {
+	lru := New(&CacheConfig{TTL: 20 * time.Millisecond})
+	lru.Add("k1", String("v1"))
+	lru.AddWithTTL("k2", String("v2"), time.Hour)
+	lru.AddWithTTL("k3", String("v3"), 0)
+
+	if _, ok := lru.Get("k1"); !ok {
+		t.Fatal("k1 should not expire yet")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := lru.Get("k1"); ok {
+		t.Fatal("k1 should be expired")
+	}
+	if _, ok := lru.Get("k2"); !ok {
+		t.Fatal("k2 should not expire")
+	}
+	if _, ok := lru.Get("k3"); !ok {
+		t.Fatal("k3 should never expire")
+	}
+
+	lru.AddWithTTL("k2", String("v22"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if n := lru.RemoveExpired(); n != 1 || lru.Len() != 1 {
+		t.Fatalf("expected 1 expired entry removed, got %d, len %d", n, lru.Len())
+	}
+}
+
+func TestPeek(t *testing.T) {
+	k1, k2, k3 := "key1", "key2", "k3"
+	v1, v2, v3 := "value1", "value2", "v3"
+	lru := New(&CacheConfig{MaxBytes: int64(len(k1 + k2 + v1 + v2))})
+	lru.Add(k1, String(v1))
+	lru.Add(k2, String(v2))
+
+	// Peek 不调整淘汰顺序，k1 仍然最先被淘汰
+	if v, ok := lru.Peek(k1); !ok || string(v.(String)) != v1 {
+		t.Fatal("peek key1 failed")
+	}
+	lru.Add(k3, String(v3))
+	if _, ok := lru.Peek(k1); ok || lru.Len() != 2 {
+		t.Fatal("key1 should be evicted")
+	}
+}
+
+func TestGetRefreshesRecency(t *testing.T) {
+	lru := New(&CacheConfig{MaxEntries: 3})
+	lru.Add("k1", String("v1"))
+	lru.Add("k2", String("v2"))
+	lru.Add("k3", String("v3"))
+
+	// Get 将 k1 移动到队首，链表中不会出现重复节点
+	for i := 0; i < 3; i++ {
+		if _, ok := lru.Get("k1"); !ok {
+			t.Fatal("get k1 failed")
+		}
+	}
+	if lru.ll.Len() != 3 || lru.Len() != 3 {
+		t.Fatalf("list corrupted, ll len %d cache len %d", lru.ll.Len(), len(lru.cache))
+	}
+	if lru.ll.Front().Value.(*entry).key != "k1" {
+		t.Fatal("k1 should be the most recently used")
+	}
+
+	// 淘汰最久未访问的 k2，而不是 k1
+	lru.Add("k4", String("v4"))
+	if _, ok := lru.Get("k2"); ok {
+		t.Fatal("k2 should be evicted")
+	}
+	if _, ok := lru.Get("k1"); !ok {
+		t.Fatal("k1 should not be evicted")
+	}
+	for ele := lru.ll.Front(); ele != nil; ele = ele.Next() {
+		if lru.cache[ele.Value.(*entry).key] != ele {
+			t.Fatal("list and map are out of sync")
+		}
+	}
+}
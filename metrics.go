@@ -0,0 +1,254 @@
+package gocache
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 远程节点请求耗时直方图的桶(秒)，与 Prometheus 客户端的默认值一致
+var defaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram 累积直方图
+type histogram struct {
+	mu      sync.Mutex
+	buckets []float64 // 每个桶的上界，递增
+	counts  []uint64  // 落在每个桶中的数目(非累积)
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+// observe 记录一个值，h为nil时不做任何处理
+func (h *histogram) observe(v float64) {
+	if h == nil {
+		return
+	}
+	i := sort.SearchFloat64s(h.buckets, v)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += v
+	h.count++
+}
+
+// snapshot 返回累积的桶计数、总和以及总数
+func (h *histogram) snapshot() (cumulative []uint64, sum float64, count uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	cumulative = make([]uint64, len(h.counts))
+	var acc uint64
+	for i, c := range h.counts {
+		acc += c
+		cumulative[i] = acc
+	}
+	return cumulative, h.sum, h.count
+}
+
+// metricsWriter 按 Prometheus 文本格式输出指标
+type metricsWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (m *metricsWriter) printf(format string, args ...interface{}) {
+	if m.err == nil {
+		_, m.err = fmt.Fprintf(m.w, format, args...)
+	}
+}
+
+// header 输出指标的 HELP 和 TYPE
+func (m *metricsWriter) header(name, typ, help string) {
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample 输出一个样本，labels 为 name, value 交替排列
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// MetricsHandler 返回以 Prometheus 文本格式输出指标的 http.Handler，与 HTTPPool 挂载在一起：
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/_cache/", pool)
+//	mux.Handle("/metrics", pool.MetricsHandler())
+//
+// 输出本进程中所有group的统计信息，以及本节点到各个远程节点的请求耗时
+func (p *HTTPPool) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m := &metricsWriter{w: w}
+		writeGroupMetrics(m, sortedGroups())
+		p.writePeerMetrics(m)
+		if m.err != nil {
+			p.Logf("write metrics: %v", m.err)
+		}
+	})
+}
+
+// sortedGroups 按名字排序返回所有group
+func sortedGroups() []*Group {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	list := make([]*Group, 0, len(groups))
+	for _, g := range groups {
+		list = append(list, g)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	return list
+}
+
+func writeGroupMetrics(m *metricsWriter, list []*Group) {
+	stats := make([]Stats, len(list))
+	for i, g := range list {
+		stats[i] = g.Stats()
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(s Stats) int64
+	}{
+		{"gocache_gets_total", "Total number of Get requests.",
+			func(s Stats) int64 { return s.Gets }},
+		{"gocache_cache_hits_total", "Total number of local cache hits.",
+			func(s Stats) int64 { return s.CacheHits }},
+		{"gocache_loads_total", "Total number of cache misses that needed a load.",
+			func(s Stats) int64 { return s.Loads }},
+		{"gocache_loads_deduped_total", "Total number of loads after singlereq deduplication.",
+			func(s Stats) int64 { return s.LoadsDeduped }},
+		{"gocache_peer_loads_total", "Total number of successful loads from peers.",
+			func(s Stats) int64 { return s.PeerLoads }},
+		{"gocache_peer_errors_total", "Total number of failed loads from peers.",
+			func(s Stats) int64 { return s.PeerErrors }},
+		{"gocache_local_loads_total", "Total number of successful loads from the data source.",
+			func(s Stats) int64 { return s.LocalLoads }},
+		{"gocache_load_errors_total", "Total number of failed loads from the data source.",
+			func(s Stats) int64 { return s.LoadErrors }},
+		{"gocache_stale_hits_total", "Total number of stale values served while revalidating.",
+			func(s Stats) int64 { return s.StaleHits }},
+		{"gocache_stale_errors_total", "Total number of stale values served after a failed load.",
+			func(s Stats) int64 { return s.StaleErrors }},
+		{"gocache_refreshes_total", "Total number of entries refreshed ahead of expiry.",
+			func(s Stats) int64 { return s.Refreshes }},
+		{"gocache_refresh_drops_total", "Total number of background refreshes dropped because the queue was full.",
+			func(s Stats) int64 { return s.RefreshDrops }},
+		{"gocache_evictions_total", "Total number of evicted cache entries.",
+			func(s Stats) int64 { return s.Evictions }},
+	}
+	for _, c := range counters {
+		m.header(c.name, "counter", c.help)
+		for i, g := range list {
+			m.sample(c.name, float64(c.value(stats[i])), "group", g.name)
+		}
+	}
+
+	m.header("gocache_hit_ratio", "gauge", "Ratio of local cache hits to Get requests.")
+	for i, g := range list {
+		ratio := 0.0
+		if stats[i].Gets > 0 {
+			ratio = float64(stats[i].CacheHits) / float64(stats[i].Gets)
+		}
+		m.sample("gocache_hit_ratio", ratio, "group", g.name)
+	}
+
+	caches := []struct {
+		label string
+		which CacheType
+	}{{"main", MainCache}, {"hot", HotCache}}
+	m.header("gocache_cache_bytes", "gauge", "Bytes used by cache entries.")
+	for _, g := range list {
+		for _, c := range caches {
+			m.sample("gocache_cache_bytes", float64(g.CacheStats(c.which).Bytes), "group", g.name, "cache", c.label)
+		}
+	}
+	m.header("gocache_cache_items", "gauge", "Number of cache entries.")
+	for _, g := range list {
+		for _, c := range caches {
+			m.sample("gocache_cache_items", float64(g.CacheStats(c.which).Items), "group", g.name, "cache", c.label)
+		}
+	}
+
+	m.header("gocache_inflight_loads", "gauge", "Number of in-flight loads in singlereq.")
+	for _, g := range list {
+		m.sample("gocache_inflight_loads", float64(g.singleReq.InFlight()), "group", g.name)
+	}
+}
+
+// writePeerMetrics 输出本节点到各个远程节点的请求耗时直方图
+func (p *HTTPPool) writePeerMetrics(m *metricsWriter) {
+	p.mu.Lock()
+	nodes := make([]string, 0, len(p.httpGetters))
+	latency := make(map[string]*histogram, len(p.httpGetters))
+	for nodeKey, getter := range p.httpGetters {
+		if nodeKey != p.selfAddr && getter.latency != nil {
+			nodes = append(nodes, nodeKey)
+			latency[nodeKey] = getter.latency
+		}
+	}
+	p.mu.Unlock()
+	sort.Strings(nodes)
+
+	const name = "gocache_peer_request_duration_seconds"
+	m.header(name, "histogram", "Latency of Get requests to peers.")
+	for _, node := range nodes {
+		h := latency[node]
+		cumulative, sum, count := h.snapshot()
+		for i, le := range h.buckets {
+			m.sample(name+"_bucket", float64(cumulative[i]), "peer", node, "le", formatValue(le))
+		}
+		m.sample(name+"_bucket", float64(count), "peer", node, "le", "+Inf")
+		m.sample(name+"_sum", sum, "peer", node)
+		m.sample(name+"_count", float64(count), "peer", node)
+	}
+}
@@ -0,0 +1,370 @@
+package gocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	pb "github.com/devhg/gocache/gocachepb"
+	"github.com/devhg/gocache/singlereq"
+)
+
+// BatchDataGetter 可选接口，DataGetter 同时实现该接口时，
+// GetMulti 用一次 GetMany 加载本节点负责的所有未命中的key，使用group的默认过期时间。
+// 设置 WithBatchWindow 后，Get 并发的未命中也会合并为一次 GetMany 调用。
+// 返回的 map 中不存在的key视为 ErrNotFound
+type BatchDataGetter interface {
+	GetMany(keys []string) (map[string][]byte, error)
+}
+
+// BatchNodeGetter 可选接口，NodeGetter 同时实现该接口时，
+// GetMulti 对每个远程节点只发送一次批量请求
+type BatchNodeGetter interface {
+	GetMulti(context.Context, *pb.BatchRequest, *pb.BatchResponse) error
+}
+
+// multiResult GetMulti 的结果，可能被多个协程同时写入
+type multiResult struct {
+	mu    sync.Mutex
+	views map[string]ByteView
+	errs  map[string]error // 包括 ErrNotFound
+}
+
+// get 返回key的结果，用于批量加载结束后返回给 singlereq 中等待的调用方
+func (r *multiResult) get(key string) (ByteView, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err, ok := r.errs[key]; ok {
+		return ByteView{}, err
+	}
+	if val, ok := r.views[key]; ok {
+		return val, nil
+	}
+	return ByteView{}, fmt.Errorf("gocache: %s is missing from the batch load", key)
+}
+
+func (r *multiResult) set(key string, val ByteView, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil && val.notFound {
+		err = ErrNotFound
+	}
+	if err != nil {
+		r.errs[key] = err
+		return
+	}
+	r.views[key] = val
+}
+
+// GetMulti 批量获取缓存值，返回的 map 中只包含找到的key。
+// 未命中的key按 NodePicker 分组，每个远程节点只请求一次(需要实现 BatchNodeGetter)，
+// 本节点负责的key通过 BatchDataGetter 一次性加载。批量加载的key同样经过 singlereq，
+// 与并发的 Get 共享同一次加载。GetMany 不返回过期时间，通过 GetMany 加载的key
+// 使用group的默认过期时间，不会调用 TTLGetter。
+// 部分key加载失败时，返回其他key的结果和第一个失败的错误
+func (g *Group) GetMulti(ctx context.Context, keys []string) (map[string]ByteView, error) {
+	views, errs := g.getMulti(ctx, keys)
+	for _, key := range keys {
+		if err, ok := errs[key]; ok && !errors.Is(err, ErrNotFound) {
+			return views, err
+		}
+	}
+	return views, nil
+}
+
+// getMulti 返回找到的缓存值，以及每个没有找到的key对应的错误
+func (g *Group) getMulti(ctx context.Context, keys []string) (map[string]ByteView, map[string]error) {
+	res := &multiResult{
+		views: make(map[string]ByteView, len(keys)),
+		errs:  make(map[string]error),
+	}
+
+	var (
+		misses []string
+		passed []string // 通过布隆过滤器的key，用于统计误判
+		seen   = make(map[string]bool, len(keys))
+		stale  = make(map[string]ByteView) // 过期的旧值，加载失败时使用
+		now    = time.Now()
+	)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			res.set(key, ByteView{}, fmt.Errorf("key is required"))
+			continue
+		}
+		g.stats.gets.Add(1)
+
+		if byteView, ok := g.lookupCache(key); ok {
+			if g.cacheHit(key, byteView, now) {
+				g.stats.cacheHits.Add(1)
+				res.set(key, byteView, nil)
+				continue
+			}
+			stale[key] = byteView
+		} else if g.filter != nil {
+			// 本机没有缓存时，由布隆过滤器拦截一定不存在的key
+			if !g.filter.allow(key) {
+				res.set(key, ByteView{}, ErrNotFound)
+				continue
+			}
+			passed = append(passed, key)
+		}
+		misses = append(misses, key)
+	}
+
+	g.loadMulti(ctx, misses, res)
+	for key, byteView := range stale {
+		if err, ok := res.errs[key]; ok && g.staleOnError(key, byteView, err) {
+			delete(res.errs, key)
+			res.views[key] = byteView
+		}
+	}
+
+	for _, key := range passed {
+		if errors.Is(res.errs[key], ErrNotFound) {
+			g.filter.falsePositives.Add(1)
+		}
+	}
+	return res.views, res.errs
+}
+
+// loadMulti 加载本机缓存中没有的key
+func (g *Group) loadMulti(ctx context.Context, keys []string, res *multiResult) {
+	var (
+		nodes  = make(map[BatchNodeGetter][]string)
+		local  []string // 本节点负责，使用 BatchDataGetter 加载
+		single []string // 不支持批量加载，逐个调用 load
+	)
+	batchGetter, batchLocal := g.dataGetter.(BatchDataGetter)
+	for _, key := range keys {
+		if g.picker != nil {
+			if nodeGetter, ok := g.picker.PickNode(key); ok {
+				if batchNode, ok := nodeGetter.(BatchNodeGetter); ok {
+					nodes[batchNode] = append(nodes[batchNode], key)
+				} else {
+					single = append(single, key)
+				}
+				continue
+			}
+		}
+		if batchLocal {
+			local = append(local, key)
+		} else {
+			single = append(single, key)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for nodeGetter, nodeKeys := range nodes {
+		wg.Add(1)
+		go func(nodeGetter BatchNodeGetter, nodeKeys []string) {
+			defer wg.Done()
+			g.loadBatch(ctx, nodeKeys, res, func(keys []string, batch *multiResult) {
+				failed := g.getManyFromNode(ctx, nodeGetter, keys, batch)
+				g.loadFallback(ctx, failed, batch)
+			})
+		}(nodeGetter, nodeKeys)
+	}
+	for _, key := range single {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			byteView, err := g.load(ctx, key)
+			res.set(key, byteView, err)
+		}(key)
+	}
+	if len(local) > 0 {
+		g.loadBatch(ctx, local, res, func(keys []string, batch *multiResult) {
+			g.getManyLocally(batchGetter, keys, batch)
+		})
+	}
+	wg.Wait()
+}
+
+// loadBatch 为每个key在 singlereq 中注册一次加载，没有进行中加载的key合并为一次 load 调用，
+// 并发的 Get 等待批量加载的结果，而不会再访问一次数据源或远程节点；已经在加载的key等待进行中的加载
+func (g *Group) loadBatch(ctx context.Context, keys []string, res *multiResult,
+	load func(keys []string, batch *multiResult)) {
+	var (
+		batch = &multiResult{
+			views: make(map[string]ByteView, len(keys)),
+			errs:  make(map[string]error),
+		}
+		done    = make(chan struct{})
+		leaders []string
+		results = make([]<-chan singlereq.Result[ByteView], len(keys))
+	)
+	for i, key := range keys {
+		key := key
+		ch, started := g.singleReq.Start(key, func() (ByteView, error) {
+			<-done
+			return batch.get(key)
+		})
+		results[i] = ch
+		if started {
+			leaders = append(leaders, key)
+		}
+	}
+	g.stats.loads.Add(int64(len(keys)))
+	g.stats.loadsDeduped.Add(int64(len(leaders)))
+
+	func() {
+		// load panic 时等待的调用方也会返回
+		defer close(done)
+		if len(leaders) > 0 {
+			load(leaders, batch)
+		}
+	}()
+
+	for i, key := range keys {
+		select {
+		case r := <-results[i]:
+			res.set(key, r.Val, r.Err)
+		case <-ctx.Done():
+			res.set(key, ByteView{}, ctx.Err())
+		}
+	}
+}
+
+// loadFallback 从本地数据源加载远程节点加载失败的key。
+// key 已经在 singlereq 中注册，这里不能再经过 singlereq，否则会等待自己
+func (g *Group) loadFallback(ctx context.Context, keys []string, res *multiResult) {
+	if len(keys) == 0 {
+		return
+	}
+	if err := ctx.Err(); err != nil {
+		// 调用方已经放弃，远程节点失败的key不再从本地数据源加载
+		for _, key := range keys {
+			res.set(key, ByteView{}, err)
+		}
+		return
+	}
+	if batchGetter, ok := g.dataGetter.(BatchDataGetter); ok {
+		g.getManyLocally(batchGetter, keys, res)
+		return
+	}
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			// 不经过 singlereq，需要自己处理 DataGetter 的 panic
+			defer func() {
+				if r := recover(); r != nil {
+					g.stats.loadErrors.Add(1)
+					log.Println("[goCache] Panic while loading", key, r)
+					res.set(key, ByteView{}, &singlereq.PanicError{Value: r, Stack: debug.Stack()})
+				}
+			}()
+			byteView, err := g.getLocally(ctx, key)
+			res.set(key, byteView, err)
+		}(key)
+	}
+	wg.Wait()
+}
+
+// getManyFromNode 向远程节点发送一次批量请求，返回加载失败的key
+func (g *Group) getManyFromNode(ctx context.Context, getter BatchNodeGetter,
+	keys []string, res *multiResult) (failed []string) {
+	response := &pb.BatchResponse{}
+	gens := make(map[string]uint64, len(keys))
+	for _, key := range keys {
+		gens[key] = g.hotCache.generation(key)
+	}
+	err := getter.GetMulti(ctx, &pb.BatchRequest{Group: g.name, Keys: keys}, response)
+	if err != nil {
+		if err := abandoned(ctx, err); err != nil {
+			for _, key := range keys {
+				res.set(key, ByteView{}, err)
+			}
+			return nil
+		}
+		g.stats.peerErrors.Add(1)
+		log.Println("[goCache] Failed to get from other node", err)
+		return keys
+	}
+
+	got := make(map[string]bool, len(keys))
+	for _, entry := range response.GetEntries() {
+		key := entry.GetKey()
+		got[key] = true
+		switch {
+		case entry.GetError() != "":
+			g.stats.peerErrors.Add(1)
+			log.Println("[goCache] Failed to get from other node", entry.GetError())
+			failed = append(failed, key)
+		case entry.GetNotFound():
+			g.stats.peerLoads.Add(1)
+			res.set(key, ByteView{}, ErrNotFound)
+		default:
+			g.stats.peerLoads.Add(1)
+			byteView := ByteView{b: entry.GetValue(), e: expireFromUnixNano(entry.GetExpire())}
+			g.sampleHotCache(key, byteView, gens[key])
+			res.set(key, byteView, nil)
+		}
+	}
+	// 响应中缺少的key
+	for _, key := range keys {
+		if !got[key] {
+			failed = append(failed, key)
+		}
+	}
+	return failed
+}
+
+// getManyLocally 用 GetMany 从数据源加载多个key，设置了 WithBatchWindow 时每次最多加载 maxBatch 个
+func (g *Group) getManyLocally(getter BatchDataGetter, keys []string, res *multiResult) {
+	if g.batcher != nil {
+		for len(keys) > g.batcher.maxBatch {
+			g.getManyLocally(getter, keys[:g.batcher.maxBatch:g.batcher.maxBatch], res)
+			keys = keys[g.batcher.maxBatch:]
+		}
+	}
+
+	gens := make([]uint64, len(keys))
+	for i, key := range keys {
+		gens[i] = g.mainCache.generation(key)
+	}
+	values, err := callGetMany(getter, keys)
+	if err != nil {
+		g.stats.loadErrors.Add(int64(len(keys)))
+		log.Println("[goCache] Failed to get from dataSource", err)
+		for _, key := range keys {
+			res.set(key, ByteView{}, err)
+		}
+		return
+	}
+
+	for i, key := range keys {
+		g.stats.localLoads.Add(1)
+		value, ok := values[key]
+		if !ok {
+			g.populateNotFound(key, gens[i])
+			res.set(key, ByteView{}, ErrNotFound)
+			continue
+		}
+		byteView := g.newByteView(value, 0)
+		g.populateCache(key, byteView, gens[i])
+		res.set(key, byteView, nil)
+	}
+}
+
+// callGetMany 调用 GetMany，panic 时与 Group.Get 一样转换为 *singlereq.PanicError 返回，
+// 批量加载在 GetMulti 的协程中执行，panic 不能让进程退出
+func callGetMany(getter BatchDataGetter, keys []string) (values map[string][]byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			values, err = nil, &singlereq.PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return getter.GetMany(keys)
+}
@@ -0,0 +1,158 @@
+package gocache
+
+import (
+	"fmt"
+	"time"
+)
+
+const defaultCleanupInterval = time.Minute
+
+// GroupOption 创建Group时的可选配置
+type GroupOption func(*Group)
+
+// WithTTL 设置group中缓存的默认过期时间，0表示永不过期
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithCleanupInterval 设置后台定期清理过期缓存的间隔
+// 设置了默认过期时间但没有指定间隔时，使用defaultCleanupInterval
+func WithCleanupInterval(interval time.Duration) GroupOption {
+	return func(g *Group) {
+		g.cleanupInterval = interval
+	}
+}
+
+// WithTTLJitter 为过期时间增加 ±jitter 比例的随机抖动，取值范围(0, 1)
+// 例如 0.1 表示实际过期时间在 ttl*0.9 ~ ttl*1.1 之间随机，
+// 避免同一批加载的缓存在同一时刻全部过期，引起缓存雪崩
+func WithTTLJitter(jitter float64) GroupOption {
+	return func(g *Group) {
+		if jitter < 0 || jitter >= 1 {
+			panic("ttl jitter must be in [0, 1)")
+		}
+		g.ttlJitter = jitter
+	}
+}
+
+// WithNegativeTTL 设置空值缓存的过期时间，0表示不缓存空值
+// DataGetter 返回 ErrNotFound 时，在过期之前的请求都直接返回 ErrNotFound，
+// 不会再访问数据源，防止缓存穿透
+func WithNegativeTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negativeTTL = ttl
+	}
+}
+
+// WithStaleWhileRevalidate 缓存过期后的 window 时间内，Get 直接返回过期的旧值，
+// 同时在后台刷新(同一个key同时只有一次刷新)，调用方不需要等待加载。空值缓存不受影响
+func WithStaleWhileRevalidate(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleWhileRevalidate = window
+	}
+}
+
+// WithStaleIfError 缓存过期后的 window 时间内，从远程节点和数据源加载失败时返回过期的旧值，而不是错误。
+// 加载返回 ErrNotFound 时不返回旧值
+func WithStaleIfError(window time.Duration) GroupOption {
+	return func(g *Group) {
+		g.staleIfError = window
+	}
+}
+
+// WithRefreshAhead 缓存在过期前最后 factor 比例的存活时间内被访问时，提前在后台重新加载，
+// 热点key不会因为过期而出现未命中。factor 取值范围(0, 1)，
+// 例如 0.2 表示 ttl 为1分钟的缓存在过期前的最后12秒内被访问时刷新。
+// 刷新由 workers 个后台协程执行，队列已满时放弃刷新，避免大量key同时刷新时压垮数据源；
+// WithStaleWhileRevalidate 的后台刷新同样使用这些协程
+func WithRefreshAhead(factor float64, workers int) GroupOption {
+	return func(g *Group) {
+		if factor <= 0 || factor >= 1 {
+			panic("refresh ahead factor must be in (0, 1)")
+		}
+		if workers <= 0 {
+			panic("refresh workers must be positive")
+		}
+		g.refreshAhead = factor
+		g.refresher = &refresher{workers: workers}
+	}
+}
+
+// WithBloomFilter 开启布隆过滤器，Get 时直接拒绝一定不存在的key，不会访问远程节点和数据源
+// expectedKeys 预计key数目，fpRate 期望误判率。通过 Group.AddKeys 或 WithBloomFilterRebuild 添加key
+func WithBloomFilter(expectedKeys uint, fpRate float64) GroupOption {
+	return func(g *Group) {
+		f := g.keyFilter()
+		f.expected = expectedKeys
+		f.fpRate = fpRate
+	}
+}
+
+// WithBloomFilterRebuild 创建group时使用keys构建布隆过滤器，并且每隔interval重建一次，
+// interval<=0 时只在创建时构建一次
+func WithBloomFilterRebuild(interval time.Duration, keys KeysFunc) GroupOption {
+	return func(g *Group) {
+		f := g.keyFilter()
+		f.rebuildInterval = interval
+		f.keys = keys
+	}
+}
+
+// WithHotCache 开启热点缓存，ratio 为热点缓存占 cacheBytes 的比例，取值范围[0, 1)
+// 从远程节点获取的值会按一定概率缓存在本节点的热点缓存中，减少热点key的远程请求。
+// 负责节点上的值更新后，热点缓存中的副本最多在1分钟内过期，需要立即生效时调用 InvalidateAll
+func WithHotCache(ratio float64) GroupOption {
+	return func(g *Group) {
+		if ratio < 0 || ratio >= 1 {
+			panic("hot cache ratio must be in [0, 1)")
+		}
+		g.hotCacheRatio = ratio
+	}
+}
+
+// WithEvictionPolicy 使用内置的缓存淘汰策略，默认为 LRU
+func WithEvictionPolicy(policy EvictionPolicy) GroupOption {
+	newStore, ok := policyStores[policy]
+	if !ok {
+		panic(fmt.Sprintf("unknown eviction policy: %d", policy))
+	}
+	return WithStore(newStore)
+}
+
+// WithStore 使用自定义的 Store，主缓存和热点缓存各调用一次 newStore 创建
+func WithStore(newStore NewStoreFunc) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newStore = newStore
+		g.hotCache.newStore = newStore
+	}
+}
+
+// WithShards 将主缓存和热点缓存分别分为n个分片，每个分片有自己的锁，容量平均分配，
+// 减少多核并发访问时的锁竞争，默认为1个分片
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		if n <= 0 {
+			panic("shards must be positive")
+		}
+		g.mainCache.nshards = n
+		g.hotCache.nshards = n
+	}
+}
+
+// WithBatchWindow 合并并发的缓存未命中：第一个未命中的key到达后等待 window，
+// 期间其他key的加载合并为一次 BatchDataGetter.GetMany 调用，达到 maxBatch 个key时立即加载。
+// maxBatch<=0 时使用 defaultMaxBatch，DataGetter 必须实现 BatchDataGetter
+func WithBatchWindow(window time.Duration, maxBatch int) GroupOption {
+	return func(g *Group) {
+		getter, ok := g.dataGetter.(BatchDataGetter)
+		if !ok {
+			panic("WithBatchWindow requires a BatchDataGetter")
+		}
+		if maxBatch <= 0 {
+			maxBatch = defaultMaxBatch
+		}
+		g.batcher = &batchLoader{getter: getter, window: window, maxBatch: maxBatch}
+	}
+}
@@ -0,0 +1,61 @@
+package gocache
+
+import "sync"
+
+/**
+读缓冲 ---让读操作只需要读锁
+
+Store.Get 需要调整淘汰顺序(移动链表节点、增加访问次数等)，在读锁下调用会破坏内部数据结构，
+而每次读取都加写锁会让所有读操作串行。参考 Ristretto 的做法：
+* 读操作在读锁下调用 Store.Peek，不修改 Store
+* 访问过的key记录到读缓冲中，缓冲满了之后由当前读操作加写锁，批量调用 Store.Get 补上访问记录
+读缓冲分为多个条带(stripe)，按key的哈希值选择，每个条带有自己的锁，临界区只有一次 append。
+写锁的开销被摊薄到 readStripeSize 次读取，淘汰顺序只是稍有延迟。
+添加缓存时本来就持有写锁，并且可能触发淘汰，会先补上所有条带中的访问记录。
+*/
+
+const (
+	readStripes    = 16 // 每个分片的读缓冲条带数目
+	readStripeSize = 64 // 每个条带缓存的访问记录数目
+)
+
+type readBuffer struct {
+	stripes [readStripes]readStripe
+}
+
+type readStripe struct {
+	sync.Mutex
+	keys []string
+}
+
+// push 记录一次对key的访问，条带满时返回缓冲的访问记录，由调用者处理
+func (b *readBuffer) push(hash uint32, key string) []string {
+	// 分片由哈希值的低位决定，这里使用高位，使同一分片中的key分散到不同条带
+	s := &b.stripes[(hash>>16)%readStripes]
+	s.Lock()
+	defer s.Unlock()
+
+	if s.keys == nil {
+		s.keys = make([]string, 0, readStripeSize)
+	}
+	s.keys = append(s.keys, key)
+	if len(s.keys) < readStripeSize {
+		return nil
+	}
+	keys := s.keys
+	s.keys = nil
+	return keys
+}
+
+// flush 取出所有条带中缓冲的访问记录
+func (b *readBuffer) flush() []string {
+	var keys []string
+	for i := range b.stripes {
+		s := &b.stripes[i]
+		s.Lock()
+		keys = append(keys, s.keys...)
+		s.keys = s.keys[:0]
+		s.Unlock()
+	}
+	return keys
+}
@@ -0,0 +1,45 @@
+package gocache
+
+// 等待刷新的key数目上限，队列已满时放弃新的刷新
+const refreshQueueSize = 1024
+
+// refresher 固定数目的后台刷新协程。
+// 大量key同时需要刷新时在队列中排队，同时访问数据源的刷新不超过 workers 个
+type refresher struct {
+	workers int
+	queue   chan string
+	done    chan struct{} // 关闭后刷新协程退出
+}
+
+// start 启动刷新协程，每个协程依次对队列中的key调用 reload
+func (r *refresher) start(reload func(key string)) {
+	r.queue = make(chan string, refreshQueueSize)
+	r.done = make(chan struct{})
+	for i := 0; i < r.workers; i++ {
+		go func(queue <-chan string, done <-chan struct{}) {
+			for {
+				select {
+				case key := <-queue:
+					reload(key)
+				case <-done:
+					return
+				}
+			}
+		}(r.queue, r.done)
+	}
+}
+
+// submit 将key加入刷新队列，队列已满时返回false，不会阻塞调用方
+func (r *refresher) submit(key string) bool {
+	select {
+	case r.queue <- key:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop 关闭刷新协程，正在进行的刷新会继续完成
+func (r *refresher) stop() {
+	close(r.done)
+}
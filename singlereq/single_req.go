@@ -1,48 +1,188 @@
 package singlereq
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// ErrGoexit fn 调用了 runtime.Goexit 时，其他等待的调用方得到的错误
+var ErrGoexit = errors.New("singlereq: runtime.Goexit was called")
+
+// PanicError fn panic 时所有调用方得到的错误，包含 panic 的值和调用栈
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singlereq: panic: %v\n\n%s", p.Value, p.Stack)
+}
+
+// Unwrap panic 的值是 error 时返回该值
+func (p *PanicError) Unwrap() error {
+	err, _ := p.Value.(error)
+	return err
+}
 
 // 解决缓存击穿，缓存雪崩
-type call struct {
+type call[V any] struct {
 	wg  sync.WaitGroup
-	val interface{}
+	val V
 	err error
+
+	dups  int                // 共享这次请求的其他调用方数目
+	chans []chan<- Result[V] // DoChan 调用方等待结果的channel
+}
+
+// Result DoChan 返回的结果
+type Result[V any] struct {
+	Val    V
+	Err    error
+	Shared bool // 结果是否同时返回给了多个调用方
 }
 
-type ReqGroup struct {
+// Group 对相同key的并发请求去重，K 为key的类型，V 为结果的类型。
+// 零值可以直接使用
+type Group[K comparable, V any] struct {
 	sync.Mutex // 保护map
-	keyCall    map[string]*call
+	keyCall    map[K]*call[V]
 }
 
+// ReqGroup 以 string 为key、interface{} 为结果的 Group，兼容原有的用法
+type ReqGroup = Group[string, interface{}]
+
 // 确保并发环境下，相同的key只会被请求一次
 // 使用 sync.WaitGroup锁 避免重入。
 // 无论Do并发被调用多少次，fn只会执行一次
-// 等待 fn 调用结束了，返回返回值或错误。
+// 等待 fn 调用结束了，返回返回值或错误。fn panic 时所有调用方得到 *PanicError
 // 同步锁mu的目的是保护map
-func (rg *ReqGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+func (rg *Group[K, V]) Do(key K, fn func() (V, error)) (V, error) {
 	rg.Lock()
 	if rg.keyCall == nil {
-		rg.keyCall = make(map[string]*call)
+		rg.keyCall = make(map[K]*call[V])
 	}
 
 	// 已经有一个请求在进行
 	if call, ok := rg.keyCall[key]; ok {
+		call.dups++
 		rg.Unlock()
 		call.wg.Wait()            // 有请求正在进行中，等待已经进行的请求的结果
 		return call.val, call.err // 所有的并发请求都会在此返回
 	}
 
-	c := new(call)
+	c := new(call[V])
 
 	c.wg.Add(1)         // 发起请求前加入任务
 	rg.keyCall[key] = c // 添加call， 表明key已经有请求在处理
 	rg.Unlock()
 
-	c.val, c.err = fn() // 发起请求
-	c.wg.Done()         // 请求结束
+	rg.doCall(c, key, fn) // 发起请求
+	return c.val, c.err
+}
+
+// DoChan 与 Do 相同，但不阻塞调用方，fn 在新的协程中执行，结果通过返回的channel发送
+func (rg *Group[K, V]) DoChan(key K, fn func() (V, error)) <-chan Result[V] {
+	ch, _ := rg.Start(key, fn)
+	return ch
+}
+
+// Start 与 DoChan 相同，同时返回是否发起了新的请求。返回false时加入了进行中的请求，fn 不会被执行。
+// 调用方可以据此只为新发起的请求准备数据，例如把多个key合并为一次批量加载
+func (rg *Group[K, V]) Start(key K, fn func() (V, error)) (<-chan Result[V], bool) {
+	ch := make(chan Result[V], 1)
+	rg.Lock()
+	if rg.keyCall == nil {
+		rg.keyCall = make(map[K]*call[V])
+	}
+
+	if c, ok := rg.keyCall[key]; ok {
+		c.dups++
+		c.chans = append(c.chans, ch)
+		rg.Unlock()
+		return ch, false
+	}
+
+	c := &call[V]{chans: []chan<- Result[V]{ch}}
+	c.wg.Add(1)
+	rg.keyCall[key] = c
+	rg.Unlock()
+
+	go rg.doCall(c, key, fn)
+	return ch, true
+}
+
+// DoContext 与 Do 相同，但调用方可以通过 ctx 放弃等待。
+// 放弃等待不会取消 fn，其他调用方仍然会得到结果；发起请求的调用方也可以放弃等待。
+// shared 表示结果是否同时返回给了多个调用方
+func (rg *Group[K, V]) DoContext(ctx context.Context, key K,
+	fn func() (V, error)) (v V, err error, shared bool) {
+	select {
+	case res := <-rg.DoChan(key, fn):
+		return res.Val, res.Err, res.Shared
+	case <-ctx.Done():
+		return v, ctx.Err(), false
+	}
+}
+
+// doCall 执行 fn，唤醒所有等待的调用方。
+// fn panic 时结果为 *PanicError；fn 调用 runtime.Goexit 时发起请求的协程照常退出，
+// 其他调用方得到 ErrGoexit。两种情况下key都会被删除，不会让之后的请求永远阻塞
+func (rg *Group[K, V]) doCall(c *call[V], key K, fn func() (V, error)) {
+	normalReturn := false
+	recovered := false
 
+	defer func() {
+		// 既没有正常返回也没有 panic，说明调用了 runtime.Goexit
+		if !normalReturn && !recovered {
+			var zero V
+			c.val, c.err = zero, ErrGoexit
+		}
+		c.wg.Done() // 请求结束
+
+		rg.Lock()
+		defer rg.Unlock()
+		// key 可能已经被 Forget，并且有了新的请求
+		if rg.keyCall[key] == c {
+			delete(rg.keyCall, key)
+		}
+		for _, ch := range c.chans {
+			ch <- Result[V]{Val: c.val, Err: c.err, Shared: c.dups > 0}
+		}
+	}()
+
+	func() {
+		defer func() {
+			if !normalReturn {
+				// Goexit 时 recover 返回 nil
+				if r := recover(); r != nil {
+					var zero V
+					c.val, c.err = zero, &PanicError{Value: r, Stack: debug.Stack()}
+				}
+			}
+		}()
+		c.val, c.err = fn()
+		normalReturn = true
+	}()
+
+	if !normalReturn {
+		recovered = true
+	}
+}
+
+// Forget 忘记正在进行中的key，之后的调用会重新执行 fn，而不是等待进行中的请求。
+// 已经在等待的调用方仍然会得到进行中请求的结果
+func (rg *Group[K, V]) Forget(key K) {
 	rg.Lock()
 	delete(rg.keyCall, key)
 	rg.Unlock()
-	return c.val, c.err
+}
+
+// InFlight 返回正在进行中的请求数目
+func (rg *Group[K, V]) InFlight() int {
+	rg.Lock()
+	defer rg.Unlock()
+	return len(rg.keyCall)
 }
@@ -0,0 +1,286 @@
+package singlereq
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var rg ReqGroup
+	v, err := rg.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	if v.(string) != "bar" || err != nil {
+		t.Fatalf("Do = %v, %v", v, err)
+	}
+
+	someErr := errors.New("some error")
+	if _, err = rg.Do("key", func() (interface{}, error) {
+		return nil, someErr
+	}); err != someErr {
+		t.Fatalf("Do error = %v, expected %v", err, someErr)
+	}
+}
+
+func TestDoDupSuppress(t *testing.T) {
+	var rg ReqGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	results := make(chan Result[interface{}], n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err, shared := rg.DoContext(context.Background(), "key", fn)
+			results <- Result[interface{}]{Val: v, Err: err, Shared: shared}
+		}()
+	}
+	// 等待所有调用方都加入同一个请求
+	for rg.waiters("key") < n {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("fn called %d times, expected 1", got)
+	}
+	for res := range results {
+		if res.Val.(string) != "bar" || res.Err != nil || !res.Shared {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+}
+
+func TestDoChan(t *testing.T) {
+	var rg ReqGroup
+	ch := rg.DoChan("key", func() (interface{}, error) {
+		return "bar", nil
+	})
+	res := <-ch
+	if res.Val.(string) != "bar" || res.Err != nil || res.Shared {
+		t.Fatalf("unexpected result %+v", res)
+	}
+	if rg.InFlight() != 0 {
+		t.Fatal("finished call should be removed")
+	}
+}
+
+func TestStart(t *testing.T) {
+	var rg ReqGroup
+	release := make(chan struct{})
+	first, started := rg.Start("key", func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	})
+	if !started {
+		t.Fatal("first call should start a new request")
+	}
+	second, started := rg.Start("key", func() (interface{}, error) {
+		t.Error("fn of a joined request should not run")
+		return nil, nil
+	})
+	if started {
+		t.Fatal("second call should join the in-flight request")
+	}
+	close(release)
+	for _, ch := range []<-chan Result[interface{}]{first, second} {
+		if res := <-ch; res.Val.(string) != "bar" || !res.Shared {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+}
+
+func TestDoContextCancel(t *testing.T) {
+	var rg ReqGroup
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		return "bar", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ch := rg.DoChan("key", fn)
+	if _, err, _ := rg.DoContext(ctx, "key", fn); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// 放弃等待不影响进行中的请求
+	close(release)
+	if res := <-ch; res.Val.(string) != "bar" || !res.Shared {
+		t.Fatalf("unexpected result %+v", res)
+	}
+}
+
+func TestForget(t *testing.T) {
+	var rg ReqGroup
+	release := make(chan struct{})
+	first := rg.DoChan("key", func() (interface{}, error) {
+		<-release
+		return 1, nil
+	})
+
+	rg.Forget("key")
+	second := rg.DoChan("key", func() (interface{}, error) {
+		return 2, nil
+	})
+	if res := <-second; res.Val.(int) != 2 || res.Shared {
+		t.Fatalf("forgotten key should start a new call, got %+v", res)
+	}
+
+	close(release)
+	if res := <-first; res.Val.(int) != 1 {
+		t.Fatalf("in-flight call should still finish, got %+v", res)
+	}
+	if rg.InFlight() != 0 {
+		t.Fatal("finished calls should be removed")
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var rg ReqGroup
+	release := make(chan struct{})
+	fn := func() (interface{}, error) {
+		<-release
+		panic("boom")
+	}
+
+	const n = 5
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			_, err := rg.Do("key", fn)
+			errs <- err
+		}()
+	}
+	for rg.waiters("key") < n {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	// 所有调用方都得到同一个 PanicError，而不是永远阻塞
+	for i := 0; i < n; i++ {
+		var pe *PanicError
+		if err := <-errs; !errors.As(err, &pe) || pe.Value != "boom" || len(pe.Stack) == 0 {
+			t.Fatalf("expected PanicError, got %v", err)
+		}
+	}
+	if rg.InFlight() != 0 {
+		t.Fatal("panicked call should be removed")
+	}
+	if v, err := rg.Do("key", func() (interface{}, error) {
+		return "bar", nil
+	}); err != nil || v.(string) != "bar" {
+		t.Fatalf("key should be usable after panic, got %v %v", v, err)
+	}
+}
+
+func TestDoChanPanic(t *testing.T) {
+	var rg ReqGroup
+	someErr := errors.New("some error")
+	res := <-rg.DoChan("key", func() (interface{}, error) {
+		panic(someErr)
+	})
+	var pe *PanicError
+	if !errors.As(res.Err, &pe) || !errors.Is(res.Err, someErr) {
+		t.Fatalf("expected PanicError wrapping %v, got %v", someErr, res.Err)
+	}
+}
+
+func TestDoGoexit(t *testing.T) {
+	var rg ReqGroup
+	release := make(chan struct{})
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		_, _ = rg.Do("key", func() (interface{}, error) {
+			<-release
+			runtime.Goexit()
+			return nil, nil
+		})
+		t.Error("Do should not return after runtime.Goexit")
+	}()
+	for rg.waiters("key") < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := rg.Do("key", func() (interface{}, error) {
+			return "bar", nil
+		})
+		errs <- err
+	}()
+	for rg.waiters("key") < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	<-leaderDone
+	if err := <-errs; err != ErrGoexit {
+		t.Fatalf("expected ErrGoexit, got %v", err)
+	}
+	if rg.InFlight() != 0 {
+		t.Fatal("call should be removed after runtime.Goexit")
+	}
+}
+
+func TestGroupTyped(t *testing.T) {
+	type pageKey struct {
+		table string
+		page  int
+	}
+	var g Group[pageKey, []string]
+	var calls int32
+	release := make(chan struct{})
+	fn := func() ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return []string{"a", "b"}, nil
+	}
+
+	key := pageKey{table: "users", page: 1}
+	first := g.DoChan(key, fn)
+	second := g.DoChan(pageKey{table: "users", page: 1}, fn)
+	// 不同的key互不影响
+	if v, err := g.Do(pageKey{table: "users", page: 2}, func() ([]string, error) {
+		return []string{"c"}, nil
+	}); err != nil || len(v) != 1 {
+		t.Fatalf("unexpected result %v %v", v, err)
+	}
+	close(release)
+
+	for _, ch := range []<-chan Result[[]string]{first, second} {
+		if res := <-ch; len(res.Val) != 2 || !res.Shared {
+			t.Fatalf("unexpected result %+v", res)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("fn called %d times, expected 1", calls)
+	}
+}
+
+// waiters 返回等待key的调用方数目，包括发起请求的调用方
+func (rg *Group[K, V]) waiters(key K) int {
+	rg.Lock()
+	defer rg.Unlock()
+	if c, ok := rg.keyCall[key]; ok {
+		return c.dups + 1
+	}
+	return 0
+}
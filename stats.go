@@ -0,0 +1,81 @@
+package gocache
+
+import (
+	"strconv"
+	"sync/atomic"
+)
+
+// AtomicInt 并发安全的计数器
+type AtomicInt int64
+
+// Add atomically adds n to i.
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i.
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Stats group的统计信息
+type Stats struct {
+	Gets         int64 // Get 请求次数
+	CacheHits    int64 // 本机缓存(主缓存和热点缓存)命中次数
+	Loads        int64 // 缓存未命中需要加载的次数 (Gets - CacheHits)
+	LoadsDeduped int64 // 经过 singlereq 合并之后实际执行的加载次数
+	PeerLoads    int64 // 从远程节点加载成功的次数
+	PeerErrors   int64 // 从远程节点加载失败的次数
+	LocalLoads   int64 // 从数据源加载成功的次数(包括返回 ErrNotFound)
+	LoadErrors   int64 // 从数据源加载失败的次数
+	StaleHits    int64 // 在 stale-while-revalidate 窗口内返回过期旧值的次数，包含在 CacheHits 中
+	StaleErrors  int64 // 加载失败时在 stale-if-error 窗口内返回过期旧值的次数
+	Refreshes    int64 // 缓存过期前提前刷新的次数
+	RefreshDrops int64 // 刷新队列已满，放弃后台刷新的次数
+
+	Evictions int64 // 缓存淘汰次数
+	Bytes     int64 // 缓存已使用的内存
+	Items     int64 // 缓存数目
+}
+
+// groupStats group内部使用的原子计数器
+type groupStats struct {
+	gets         AtomicInt
+	cacheHits    AtomicInt
+	loads        AtomicInt
+	loadsDeduped AtomicInt
+	peerLoads    AtomicInt
+	peerErrors   AtomicInt
+	localLoads   AtomicInt
+	loadErrors   AtomicInt
+	staleHits    AtomicInt
+	staleErrors  AtomicInt
+	refreshes    AtomicInt
+	refreshDrops AtomicInt
+}
+
+// Stats 返回group的统计信息，缓存相关的数据包括主缓存和热点缓存
+func (g *Group) Stats() Stats {
+	main, hot := g.mainCache.stats(), g.hotCache.stats()
+	return Stats{
+		Gets:         g.stats.gets.Get(),
+		CacheHits:    g.stats.cacheHits.Get(),
+		Loads:        g.stats.loads.Get(),
+		LoadsDeduped: g.stats.loadsDeduped.Get(),
+		PeerLoads:    g.stats.peerLoads.Get(),
+		PeerErrors:   g.stats.peerErrors.Get(),
+		LocalLoads:   g.stats.localLoads.Get(),
+		LoadErrors:   g.stats.loadErrors.Get(),
+		StaleHits:    g.stats.staleHits.Get(),
+		StaleErrors:  g.stats.staleErrors.Get(),
+		Refreshes:    g.stats.refreshes.Get(),
+		RefreshDrops: g.stats.refreshDrops.Get(),
+		Evictions:    main.Evictions + hot.Evictions,
+		Bytes:        main.Bytes + hot.Bytes,
+		Items:        main.Items + hot.Items,
+	}
+}
@@ -0,0 +1,275 @@
+package gocache
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/devhg/gocache/arc"
+	"github.com/devhg/gocache/arena"
+	"github.com/devhg/gocache/lfu"
+	"github.com/devhg/gocache/lru"
+	"github.com/devhg/gocache/tinylfu"
+)
+
+// Store group 中缓存依赖的底层存储，决定缓存的淘汰策略。
+// 由 cache 加锁保护，实现不需要并发安全：
+// Peek 在读锁下调用，可能与其他 Peek 并发；其他方法在写锁下调用
+type Store interface {
+	// Add 添加缓存，ttl<=0表示永不过期。
+	// 不支持过期时间的 Store 可以忽略 ttl，cache 在读取时会惰性删除过期缓存
+	Add(key string, value ByteView, ttl time.Duration)
+	// Get 获取缓存并记录一次访问(调整淘汰顺序、访问频率等)
+	Get(key string) (ByteView, bool)
+	// Peek 获取缓存，不能修改任何内部状态
+	Peek(key string) (ByteView, bool)
+	Remove(key string) bool
+	Len() int
+	// Bytes 已使用的内存
+	Bytes() int64
+}
+
+// Expirer Store 的可选接口，实现后后台清理协程会定期调用 RemoveExpired
+type Expirer interface {
+	// 删除所有已过期的缓存，返回删除的数目
+	RemoveExpired() int
+}
+
+// Toucher Store 的可选接口，实现后读缓冲中的访问记录通过 Touch 补到 store 中，而不是调用 Get。
+// 不依赖访问顺序的 Store(例如 Arena)实现一个空的 Touch，避免读路径上多余的拷贝和解码
+type Toucher interface {
+	// Touch 记录一次访问，不需要返回缓存值
+	Touch(key string)
+}
+
+// StoreConfig 创建 Store 的参数
+type StoreConfig struct {
+	MaxBytes int64 // 最大使用内存
+
+	// 淘汰回调函数，缓存被淘汰或删除时必须调用，用于统计
+	OnEvicted func(key string, value ByteView)
+}
+
+// NewStoreFunc 创建 Store，group 中的每个缓存都会调用一次
+type NewStoreFunc func(config *StoreConfig) Store
+
+// EvictionPolicy 内置的缓存淘汰策略
+type EvictionPolicy int
+
+const (
+	// LRU 淘汰最近最少使用的缓存，默认策略
+	LRU EvictionPolicy = iota
+	// LFU 淘汰访问次数最少的缓存，适合热点key稳定的场景
+	LFU
+	// TinyLFU W-TinyLFU，按访问频率决定是否准入，抵抗批量扫描
+	TinyLFU
+	// ARC 自适应替换缓存，在最近访问和访问频率之间自动调节
+	ARC
+	// Arena 缓存保存在预先分配的字节数组中，按写入顺序淘汰(FIFO)。
+	// 适合大量小缓存的场景，GC 不需要扫描每个缓存。注意每条缓存额外占用约 40 字节
+	Arena
+)
+
+// 内置淘汰策略对应的 Store
+var policyStores = map[EvictionPolicy]NewStoreFunc{
+	LRU:     NewLRUStore,
+	LFU:     NewLFUStore,
+	TinyLFU: NewTinyLFUStore,
+	ARC:     NewARCStore,
+	Arena:   NewArenaStore,
+}
+
+// NewLRUStore 创建基于 lru.Cache 的 Store
+func NewLRUStore(config *StoreConfig) Store {
+	return &lruStore{lru.New(&lru.CacheConfig{
+		MaxBytes: config.MaxBytes,
+		OnEvicted: func(key string, value lru.Value) {
+			config.OnEvicted(key, value.(ByteView))
+		},
+	})}
+}
+
+type lruStore struct {
+	*lru.Cache
+}
+
+func (s *lruStore) Add(key string, value ByteView, ttl time.Duration) {
+	s.Cache.AddWithTTL(key, value, ttl)
+}
+
+func (s *lruStore) Get(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+func (s *lruStore) Peek(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Peek(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+// NewLFUStore 创建基于 lfu.Cache 的 Store
+func NewLFUStore(config *StoreConfig) Store {
+	return &lfuStore{lfu.New(&lfu.CacheConfig{
+		MaxBytes: config.MaxBytes,
+		OnEvicted: func(key string, value lfu.Value) {
+			config.OnEvicted(key, value.(ByteView))
+		},
+	})}
+}
+
+type lfuStore struct {
+	*lfu.Cache
+}
+
+func (s *lfuStore) Add(key string, value ByteView, ttl time.Duration) {
+	s.Cache.AddWithTTL(key, value, ttl)
+}
+
+func (s *lfuStore) Get(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+func (s *lfuStore) Peek(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Peek(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+// NewTinyLFUStore 创建基于 tinylfu.Cache 的 Store
+func NewTinyLFUStore(config *StoreConfig) Store {
+	return &tinyLFUStore{tinylfu.New(&tinylfu.CacheConfig{
+		MaxBytes: config.MaxBytes,
+		OnEvicted: func(key string, value tinylfu.Value) {
+			config.OnEvicted(key, value.(ByteView))
+		},
+	})}
+}
+
+type tinyLFUStore struct {
+	*tinylfu.Cache
+}
+
+func (s *tinyLFUStore) Add(key string, value ByteView, ttl time.Duration) {
+	s.Cache.AddWithTTL(key, value, ttl)
+}
+
+func (s *tinyLFUStore) Get(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+func (s *tinyLFUStore) Peek(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Peek(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+// NewARCStore 创建基于 arc.Cache 的 Store
+func NewARCStore(config *StoreConfig) Store {
+	return &arcStore{arc.New(&arc.CacheConfig{
+		MaxBytes: config.MaxBytes,
+		OnEvicted: func(key string, value arc.Value) {
+			config.OnEvicted(key, value.(ByteView))
+		},
+	})}
+}
+
+type arcStore struct {
+	*arc.Cache
+}
+
+func (s *arcStore) Add(key string, value ByteView, ttl time.Duration) {
+	s.Cache.AddWithTTL(key, value, ttl)
+}
+
+func (s *arcStore) Get(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Get(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+func (s *arcStore) Peek(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Peek(key); ok {
+		return v.(ByteView), true
+	}
+	return ByteView{}, false
+}
+
+// NewArenaStore 创建基于 arena.Cache 的 Store，ByteView 序列化后保存
+func NewArenaStore(config *StoreConfig) Store {
+	return &arenaStore{arena.New(&arena.CacheConfig{
+		MaxBytes: config.MaxBytes,
+		OnEvicted: func(key string, value []byte) {
+			config.OnEvicted(key, decodeByteView(value))
+		},
+	})}
+}
+
+type arenaStore struct {
+	*arena.Cache
+}
+
+// ByteView 序列化后的格式：expire(8) + ttl(8) + notFound(1) + b
+const byteViewHeaderSize = 17
+
+func encodeByteView(v ByteView) []byte {
+	buf := make([]byte, byteViewHeaderSize+len(v.b))
+	binary.LittleEndian.PutUint64(buf, uint64(expireToUnixNano(v.e)))
+	binary.LittleEndian.PutUint64(buf[8:], uint64(v.ttl))
+	if v.notFound {
+		buf[16] = 1
+	}
+	copy(buf[byteViewHeaderSize:], v.b)
+	return buf
+}
+
+// decodeByteView 反序列化 ByteView，返回的 ByteView 引用 data
+func decodeByteView(data []byte) ByteView {
+	return ByteView{
+		b:        data[byteViewHeaderSize:],
+		e:        expireFromUnixNano(int64(binary.LittleEndian.Uint64(data))),
+		ttl:      time.Duration(binary.LittleEndian.Uint64(data[8:])),
+		notFound: data[16] == 1,
+	}
+}
+
+func (s *arenaStore) Add(key string, value ByteView, ttl time.Duration) {
+	s.Cache.AddWithTTL(key, encodeByteView(value), ttl)
+}
+
+func (s *arenaStore) Get(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Get(key); ok {
+		return decodeByteView(v), true
+	}
+	return ByteView{}, false
+}
+
+func (s *arenaStore) Peek(key string) (ByteView, bool) {
+	if v, ok := s.Cache.Peek(key); ok {
+		return decodeByteView(v), true
+	}
+	return ByteView{}, false
+}
+
+// Touch arena 按写入顺序淘汰，不需要记录访问
+func (s *arenaStore) Touch(string) {}
+
+var (
+	_ Toucher = (*arenaStore)(nil)
+	_ Expirer = (*arenaStore)(nil)
+	_ Expirer = (*arcStore)(nil)
+	_ Expirer = (*lruStore)(nil)
+	_ Expirer = (*lfuStore)(nil)
+	_ Expirer = (*tinyLFUStore)(nil)
+)
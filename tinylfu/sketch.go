@@ -0,0 +1,86 @@
+package tinylfu
+
+/**
+Count-Min Sketch 频率估计器 ---用固定大小的计数器矩阵近似统计每个key的访问次数
+
+depth 行计数器，每行使用不同的哈希函数，key 的访问次数取各行对应计数器的最小值。
+计数器最大为15，每个计数器占一个 uint8，达到采样数目后所有计数器减半(重置)，使频率反映近期的访问情况。
+*/
+
+const (
+	sketchDepth = 4
+	maxCounter  = 15
+)
+
+// 每行哈希函数的种子
+var sketchSeeds = [sketchDepth]uint64{
+	0xc3a5c85c97cb3127, 0xb492b66fbe98f273, 0x9ae16a3b2f90404f, 0xcbf29ce484222325,
+}
+
+type cmSketch struct {
+	rows [sketchDepth][]uint8
+	mask uint64
+
+	samples    int // 自上次重置以来的访问次数
+	sampleSize int // 达到该访问次数后重置
+}
+
+// newCMSketch 创建频率估计器，width 为每行计数器数目(向上取2的幂)
+func newCMSketch(width int) *cmSketch {
+	n := 16
+	for n < width {
+		n <<= 1
+	}
+	s := &cmSketch{
+		mask:       uint64(n - 1),
+		sampleSize: 10 * n,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, n)
+	}
+	return s
+}
+
+// index 计算第i行的计数器下标
+func (s *cmSketch) index(h uint64, i int) uint64 {
+	h ^= sketchSeeds[i]
+	h = (h ^ (h >> 33)) * 0xff51afd7ed558ccd
+	h = (h ^ (h >> 33)) * 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h & s.mask
+}
+
+// increment 增加key的访问次数
+func (s *cmSketch) increment(h uint64) {
+	for i := range s.rows {
+		idx := s.index(h, i)
+		if s.rows[i][idx] < maxCounter {
+			s.rows[i][idx]++
+		}
+	}
+	s.samples++
+	if s.samples >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// estimate 估计key的访问次数
+func (s *cmSketch) estimate(h uint64) uint8 {
+	min := uint8(maxCounter)
+	for i := range s.rows {
+		if v := s.rows[i][s.index(h, i)]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset 所有计数器减半
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.samples /= 2
+}
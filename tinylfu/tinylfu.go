@@ -0,0 +1,315 @@
+package tinylfu
+
+import (
+	"container/list"
+	"hash/fnv"
+	"time"
+)
+
+/**
+W-TinyLFU ---在 LRU 前增加基于访问频率的准入策略，抵抗批量扫描对热点数据的冲刷
+
+结构：
+* window：占总容量 1% 的 LRU，新缓存先进入 window，使突发的新key有机会积累访问频率
+* main：占总容量 99% 的分段 LRU(SLRU)，分为 probation(20%) 和 protected(80%)
+	probation 中的缓存再次被访问时晋升到 protected，protected 满时最久未访问的缓存降级到 probation
+* sketch：Count-Min Sketch 统计所有key(包括已经被淘汰的)近期的访问频率
+
+准入：
+window 满时淘汰的缓存作为候选者，与 main 中即将被淘汰的缓存(probation 队尾)比较访问频率，
+频率更高的留下。只被访问一次的扫描数据频率很低，无法挤掉 main 中的热点数据。
+*/
+
+const (
+	maxBytes        = 1 << 32
+	expectedEntries = 10 << 10
+
+	windowPercent    = 1  // window 占总容量的百分比
+	protectedPercent = 80 // protected 占 main 的百分比
+)
+
+// 缓存所在的分段
+type segment uint8
+
+const (
+	window segment = iota
+	probation
+	protected
+)
+
+type Cache struct {
+	maxBytes int64 // 最大使用内存
+	nowBytes int64 // 已经使用的内存
+
+	maxWindow    int64 // window 最大使用内存
+	maxProtected int64 // protected 最大使用内存
+
+	// 默认过期时间，0表示永不过期
+	ttl time.Duration
+
+	segments [3]*list.List // window, probation, protected
+	bytes    [3]int64      // 每个分段已经使用的内存
+	cache    map[string]*list.Element
+	sketch   *cmSketch
+
+	// 是某条记录被移除时的回调函数，可以为 nil
+	onEvicted func(key string, value Value)
+}
+
+// a config for cache
+type CacheConfig struct {
+	MaxBytes int64 // 最大使用内存
+
+	// 预计缓存数目，决定频率估计器的大小和重置周期
+	ExpectedEntries int
+
+	// 默认过期时间，Add 添加的缓存使用该值，0表示永不过期
+	TTL time.Duration
+
+	// 淘汰回调函数，准入失败的候选者也会调用
+	OnEvicted func(string, Value)
+}
+
+// Value use Len to count how many bytes it takes
+type Value interface {
+	Len() int
+}
+
+type entry struct {
+	key    string
+	hash   uint64
+	value  Value
+	expire time.Time // 过期时间，零值表示永不过期
+	seg    segment
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key) + e.value.Len())
+}
+
+// expired 判断缓存是否在now时刻已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func New(config *CacheConfig) *Cache {
+	c := &Cache{
+		maxBytes: maxBytes,
+		cache:    make(map[string]*list.Element),
+	}
+	expected := expectedEntries
+	if config != nil {
+		if config.MaxBytes != 0 {
+			c.maxBytes = config.MaxBytes
+		}
+		if config.ExpectedEntries != 0 {
+			expected = config.ExpectedEntries
+		}
+		if config.TTL > 0 {
+			c.ttl = config.TTL
+		}
+		c.onEvicted = config.OnEvicted
+	}
+	for i := range c.segments {
+		c.segments[i] = list.New()
+	}
+	c.maxWindow = c.maxBytes * windowPercent / 100
+	if c.maxWindow < 1 {
+		c.maxWindow = 1
+	}
+	c.maxProtected = (c.maxBytes - c.maxWindow) * protectedPercent / 100
+	c.sketch = newCMSketch(expected)
+	return c
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// 按key 添加缓存，使用默认过期时间
+func (c *Cache) Add(key string, val Value) {
+	c.AddWithTTL(key, val, c.ttl)
+}
+
+// AddWithTTL 按key 添加缓存，并指定过期时间，ttl<=0表示永不过期
+func (c *Cache) AddWithTTL(key string, val Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+
+	// 缓存命中，更新缓存内容
+	if ele, ok := c.cache[key]; ok {
+		e := ele.Value.(*entry)
+		delta := int64(val.Len() - e.value.Len())
+		c.nowBytes += delta
+		c.bytes[e.seg] += delta
+		e.value = val
+		e.expire = expire
+		c.touch(ele)
+		c.evict()
+		return
+	}
+
+	// 缓存未命中，新缓存进入 window
+	e := &entry{key: key, hash: hash(key), value: val, expire: expire, seg: window}
+	c.cache[key] = c.segments[window].PushFront(e)
+	c.bytes[window] += e.size()
+	c.nowBytes += e.size()
+	c.evict()
+}
+
+// evict window 超出容量时，将候选者移入 main，main 超出容量时按频率决定淘汰谁
+func (c *Cache) evict() {
+	for c.bytes[window] > c.maxWindow && c.segments[window].Len() > 0 {
+		// move 后原来的 list.Element 已经失效，使用新的元素
+		candidate := c.move(c.segments[window].Back(), probation)
+		c.admit(candidate)
+	}
+	// 更新缓存后 main 可能超出容量
+	for c.nowBytes > c.maxBytes {
+		victim := c.victim(nil)
+		if victim == nil {
+			victim = c.segments[window].Back()
+		}
+		c.removeElement(victim)
+	}
+}
+
+// admit 候选者已经进入 probation，main 超出容量时与 victim 比较访问频率，频率低的被淘汰
+func (c *Cache) admit(candidate *list.Element) {
+	cf := c.sketch.estimate(candidate.Value.(*entry).hash)
+	for c.nowBytes > c.maxBytes {
+		victim := c.victim(candidate)
+		if victim == nil {
+			c.removeElement(candidate)
+			return
+		}
+		if cf > c.sketch.estimate(victim.Value.(*entry).hash) {
+			c.removeElement(victim)
+		} else {
+			c.removeElement(candidate)
+			return
+		}
+	}
+}
+
+// victim 返回 main 中下一个被淘汰的缓存：probation 队尾，probation 为空时为 protected 队尾
+func (c *Cache) victim(skip *list.Element) *list.Element {
+	for _, seg := range []segment{probation, protected} {
+		for ele := c.segments[seg].Back(); ele != nil; ele = ele.Prev() {
+			if ele != skip {
+				return ele
+			}
+		}
+	}
+	return nil
+}
+
+// move 将缓存移动到另一个分段的队首，返回新的元素
+func (c *Cache) move(ele *list.Element, seg segment) *list.Element {
+	e := ele.Value.(*entry)
+	c.segments[e.seg].Remove(ele)
+	c.bytes[e.seg] -= e.size()
+	e.seg = seg
+	ele = c.segments[seg].PushFront(e)
+	c.cache[e.key] = ele
+	c.bytes[seg] += e.size()
+	return ele
+}
+
+// touch 访问命中的缓存：window 和 protected 中移到队首，probation 中晋升到 protected
+func (c *Cache) touch(ele *list.Element) {
+	e := ele.Value.(*entry)
+	switch e.seg {
+	case window, protected:
+		c.segments[e.seg].MoveToFront(ele)
+	case probation:
+		c.move(ele, protected)
+		// protected 满时，最久未访问的缓存降级到 probation
+		for c.bytes[protected] > c.maxProtected && c.segments[protected].Len() > 1 {
+			c.move(c.segments[protected].Back(), probation)
+		}
+	}
+}
+
+// 获取缓存，无论是否命中都会记录访问频率
+func (c *Cache) Get(key string) (val Value, ok bool) {
+	ele, hit := c.cache[key]
+	if !hit {
+		c.sketch.increment(hash(key))
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	c.sketch.increment(e.hash)
+
+	// 惰性删除：访问时发现已过期则直接淘汰
+	if e.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.touch(ele)
+	return e.value, true
+}
+
+// Peek 获取缓存但不记录访问频率、不调整分段，也不删除过期缓存。
+// 不修改任何内部状态，多个 Peek 可以在读锁下并发调用
+func (c *Cache) Peek(key string) (val Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	e := ele.Value.(*entry)
+	if e.expired(time.Now()) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// 按key 删除缓存
+func (c *Cache) Remove(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	e := ele.Value.(*entry)
+	c.segments[e.seg].Remove(ele)
+
+	// 缓存容量减少
+	c.bytes[e.seg] -= e.size()
+	c.nowBytes -= e.size()
+	delete(c.cache, e.key)
+
+	if c.onEvicted != nil {
+		c.onEvicted(e.key, e.value)
+	}
+}
+
+// RemoveExpired 删除所有已过期的缓存，返回删除的数目
+func (c *Cache) RemoveExpired() int {
+	now := time.Now()
+	n := 0
+	for _, ele := range c.cache {
+		if ele.Value.(*entry).expired(now) {
+			c.removeElement(ele)
+			n++
+		}
+	}
+	return n
+}
+
+// Bytes the number of bytes used by keys and values
+func (c *Cache) Bytes() int64 {
+	return c.nowBytes
+}
+
+// Len the number of cache entries
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
@@ -0,0 +1,229 @@
+package tinylfu
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/devhg/gocache/lru"
+)
+
+type String string
+
+func (s String) Len() int {
+	return len(s)
+}
+
+func TestGet(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 1 << 10})
+	c.Add("key", String("value"))
+	c.Add("key2", String("value2"))
+
+	if val, ok := c.Get("key"); !ok || string(val.(String)) != "value" {
+		t.Fatal("get value error")
+	}
+	c.Add("key", String("v"))
+	if val, _ := c.Get("key"); string(val.(String)) != "v" {
+		t.Fatal("value should be updated")
+	}
+	if c.Bytes() != int64(len("key"+"v"+"key2"+"value2")) || c.Len() != 2 {
+		t.Fatalf("unexpected bytes %d len %d", c.Bytes(), c.Len())
+	}
+	if !c.Remove("key") || c.Len() != 1 {
+		t.Fatal("remove key failed")
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newCMSketch(64)
+	for i := 0; i < 10; i++ {
+		s.increment(1)
+	}
+	s.increment(2)
+	if s.estimate(1) != 10 || s.estimate(2) != 1 || s.estimate(3) != 0 {
+		t.Fatalf("unexpected estimates %d %d %d", s.estimate(1), s.estimate(2), s.estimate(3))
+	}
+	s.reset()
+	if s.estimate(1) != 5 {
+		t.Fatalf("counters should be halved after reset, got %d", s.estimate(1))
+	}
+}
+
+// 每个缓存占用 len("k-xxxx")+1 字节
+func key(i int) string {
+	return "k-" + strconv.Itoa(10000+i)
+}
+
+func TestScanResistance(t *testing.T) {
+	evicted := 0
+	c := New(&CacheConfig{
+		MaxBytes:        100 * 8,
+		ExpectedEntries: 100,
+		OnEvicted: func(string, Value) {
+			evicted++
+		},
+	})
+
+	// 热点数据被访问多次
+	for round := 0; round < 5; round++ {
+		for i := 0; i < 50; i++ {
+			if _, ok := c.Get(key(i)); !ok {
+				c.Add(key(i), String("v"))
+			}
+		}
+	}
+	// 批量扫描只访问一次的数据
+	for i := 1000; i < 2000; i++ {
+		if _, ok := c.Get(key(i)); !ok {
+			c.Add(key(i), String("v"))
+		}
+	}
+
+	hits := 0
+	for i := 0; i < 50; i++ {
+		if _, ok := c.Get(key(i)); ok {
+			hits++
+		}
+	}
+	if hits < 45 {
+		t.Fatalf("hot keys should survive the scan, only %d/50 hit", hits)
+	}
+	if c.Bytes() > 100*8 || evicted == 0 {
+		t.Fatalf("unexpected bytes %d, evicted %d", c.Bytes(), evicted)
+	}
+}
+
+// checkConsistency 遍历所有分段，检查统计的内存和数目与实际的缓存一致
+func checkConsistency(t *testing.T, c *Cache) {
+	t.Helper()
+	var total int64
+	n := 0
+	for seg, l := range c.segments {
+		var bytes int64
+		for ele := l.Front(); ele != nil; ele = ele.Next() {
+			e := ele.Value.(*entry)
+			if e.seg != segment(seg) {
+				t.Fatalf("%s is in segment %d, expected %d", e.key, seg, e.seg)
+			}
+			if c.cache[e.key] != ele {
+				t.Fatalf("%s is not indexed by the map", e.key)
+			}
+			bytes += e.size()
+		}
+		if bytes != c.bytes[seg] {
+			t.Fatalf("segment %d has %d bytes, recorded %d", seg, bytes, c.bytes[seg])
+		}
+		total += bytes
+		n += l.Len()
+	}
+	if total != c.nowBytes || n != len(c.cache) {
+		t.Fatalf("segments hold %d entries (%d bytes), recorded %d entries (%d bytes)",
+			n, total, len(c.cache), c.nowBytes)
+	}
+	if c.nowBytes > c.maxBytes {
+		t.Fatalf("bytes %d exceed max %d", c.nowBytes, c.maxBytes)
+	}
+}
+
+func TestConsistency(t *testing.T) {
+	c := New(&CacheConfig{MaxBytes: 100 * 8, ExpectedEntries: 100})
+	for _, k := range zipfTrace(200000, 1.01, 100000) {
+		if _, ok := c.Get(k); !ok {
+			c.Add(k, String("v"))
+		}
+	}
+	checkConsistency(t, c)
+
+	// 批量扫描
+	for i := 0; i < 5000; i++ {
+		if _, ok := c.Get(key(i)); !ok {
+			c.Add(key(i), String("v"))
+		}
+	}
+	checkConsistency(t, c)
+}
+
+func TestAddWithTTL(t *testing.T) {
+	c := New(&CacheConfig{TTL: 10 * time.Millisecond})
+	c.Add("k1", String("v1"))
+	c.AddWithTTL("k2", String("v2"), time.Hour)
+	c.AddWithTTL("k3", String("v3"), time.Millisecond)
+
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := c.Get("k1"); ok {
+		t.Fatal("k1 should be expired")
+	}
+	if n := c.RemoveExpired(); n != 1 || c.Len() != 1 {
+		t.Fatalf("expected 1 expired entry removed, got %d, len %d", n, c.Len())
+	}
+}
+
+// zipfTrace 生成服从 Zipf 分布的访问序列
+func zipfTrace(n int, s float64, keys uint64) []string {
+	r := rand.New(rand.NewSource(1))
+	z := rand.NewZipf(r, s, 1, keys-1)
+	trace := make([]string, n)
+	for i := range trace {
+		trace[i] = key(int(z.Uint64()))
+	}
+	return trace
+}
+
+// hitRatio 按访问序列访问缓存，未命中时添加，返回命中率
+func hitRatio(trace []string, get func(string) bool, add func(string)) float64 {
+	hits := 0
+	for _, k := range trace {
+		if get(k) {
+			hits++
+		} else {
+			add(k)
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+func benchmarkHitRatio(b *testing.B, s float64) {
+	const (
+		entries = 1000
+		keys    = 100000
+	)
+	trace := zipfTrace(200000, s, keys)
+	maxBytes := int64(entries * len(key(0)+"v"))
+
+	b.Run("lru", func(b *testing.B) {
+		var ratio float64
+		for i := 0; i < b.N; i++ {
+			c := lru.New(&lru.CacheConfig{MaxBytes: maxBytes, MaxEntries: entries})
+			ratio = hitRatio(trace, func(k string) bool {
+				_, ok := c.Get(k)
+				return ok
+			}, func(k string) {
+				c.Add(k, String("v"))
+			})
+		}
+		b.ReportMetric(ratio*100, "hit%")
+	})
+	b.Run("tinylfu", func(b *testing.B) {
+		var ratio float64
+		for i := 0; i < b.N; i++ {
+			c := New(&CacheConfig{MaxBytes: maxBytes, ExpectedEntries: entries})
+			ratio = hitRatio(trace, func(k string) bool {
+				_, ok := c.Get(k)
+				return ok
+			}, func(k string) {
+				c.Add(k, String("v"))
+			})
+		}
+		b.ReportMetric(ratio*100, "hit%")
+	})
+}
+
+// go test -bench HitRatio -run ^$ ./tinylfu/
+func BenchmarkHitRatioZipf1_01(b *testing.B) {
+	benchmarkHitRatio(b, 1.01)
+}
+
+func BenchmarkHitRatioZipf1_2(b *testing.B) {
+	benchmarkHitRatio(b, 1.2)
+}
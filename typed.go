@@ -0,0 +1,68 @@
+package gocache
+
+import (
+	"context"
+	"fmt"
+)
+
+// TypedGetterFunc 返回 T 的数据源回调函数，返回的值由 Codec 编码后缓存
+type TypedGetterFunc[T any] func(ctx context.Context, key string) (T, error)
+
+// TypedGroup 在 Group 的基础上使用 Codec 编解码，调用方直接读写 T。
+// 缓存和节点间传输的仍然是编码后的 []byte，各个节点需要使用相同的 Codec
+type TypedGroup[T any] struct {
+	group *Group
+	codec Codec[T]
+}
+
+// NewTypedGroup 创建 TypedGroup，opts 与 NewGroup 相同
+func NewTypedGroup[T any](name string, cacheBytes int64, codec Codec[T],
+	getter TypedGetterFunc[T], opts ...GroupOption) *TypedGroup[T] {
+	if getter == nil {
+		panic("dataGetter is needed")
+	}
+	dataGetter := ContextGetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		v, err := getter(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		return codec.Marshal(v)
+	})
+	return &TypedGroup[T]{
+		group: NewGroup(name, cacheBytes, dataGetter, opts...),
+		codec: codec,
+	}
+}
+
+// Group 返回底层的 Group，用于注册节点、查看统计信息等
+func (g *TypedGroup[T]) Group() *Group {
+	return g.group
+}
+
+// Get 获取key的缓存值并解码
+func (g *TypedGroup[T]) Get(ctx context.Context, key string) (T, error) {
+	var zero T
+	byteView, err := g.group.GetContext(ctx, key)
+	if err != nil {
+		return zero, err
+	}
+	v, err := g.codec.Unmarshal(byteView.b)
+	if err != nil {
+		return zero, fmt.Errorf("decode %s: %w", key, err)
+	}
+	return v, nil
+}
+
+// Set 编码后设置key的缓存值，见 Group.Set
+func (g *TypedGroup[T]) Set(key string, value T) error {
+	data, err := g.codec.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", key, err)
+	}
+	return g.group.Set(key, data)
+}
+
+// Remove 删除key的缓存，见 Group.Remove
+func (g *TypedGroup[T]) Remove(key string) error {
+	return g.group.Remove(key)
+}